@@ -57,7 +57,14 @@ func (a UserPassAuthenticator) Authenticate(reader io.Reader, writer io.Writer,
 	}
 
 	// Verify the password
-	if !a.Credentials.Valid(string(nup.User), string(nup.Pass), userAddr) {
+	var identity map[string]string
+	var ok bool
+	if ic, is := a.Credentials.(IdentityCredentialStore); is {
+		identity, ok = ic.Identity(string(nup.User), string(nup.Pass), userAddr)
+	} else {
+		ok = a.Credentials.Valid(string(nup.User), string(nup.Pass), userAddr)
+	}
+	if !ok {
 		if _, err := writer.Write([]byte{statute.UserPassAuthVersion, statute.AuthFailure}); err != nil {
 			return nil, err
 		}
@@ -68,11 +75,11 @@ func (a UserPassAuthenticator) Authenticate(reader io.Reader, writer io.Writer,
 		return nil, err
 	}
 	// Done
-	return &AuthContext{
-		statute.MethodUserPassAuth,
-		map[string]string{
-			"username": string(nup.User),
-			"password": string(nup.Pass),
-		},
-	}, nil
+	payload := make(map[string]string, len(identity)+2)
+	for k, v := range identity {
+		payload[k] = v
+	}
+	payload["username"] = string(nup.User)
+	payload["password"] = string(nup.Pass)
+	return &AuthContext{statute.MethodUserPassAuth, payload}, nil
 }
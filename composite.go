@@ -0,0 +1,227 @@
+package socks5
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/things-go/go-socks5/statute"
+)
+
+// composite authentication error defined
+var (
+	// ErrAuthNotApplicable is returned by a ConnAuthenticator, before
+	// replying to the client, to let the server try the next authenticator
+	ErrAuthNotApplicable = errors.New("authenticator not applicable")
+	// ErrIdentityMismatch is returned when the identities of the factors disagree
+	ErrIdentityMismatch = errors.New("authentication identities disagree")
+)
+
+// TransportIdentifier identifies the client from its connection, such as
+// by its TLS client certificate or its source address.
+type TransportIdentifier interface {
+	// Identify returns the identity of the client, and whether it was identified
+	Identify(conn net.Conn) (map[string]string, bool)
+}
+
+// ConnAuthenticator is an Authenticator which is given the client
+// connection and the offered methods, to combine the method with the
+// transport. The server uses AuthenticateConn instead of Authenticate.
+type ConnAuthenticator interface {
+	Authenticator
+	AuthenticateConn(conn net.Conn, reader io.Reader, userAddr string, methods []byte) (*AuthContext, error)
+}
+
+// TLSClientCertIdentifier identifies clients by their TLS client
+// certificate, with identity keys "tls_subject" and "username".
+// The listener's tls.Config must verify the client certificates.
+type TLSClientCertIdentifier struct {
+	// Username returns the username of a certificate,
+	// defaults to the subject common name
+	Username func(cert *x509.Certificate) string
+	// HandshakeTimeout bounds the TLS handshake with the client,
+	// defaults to 10 seconds.
+	HandshakeTimeout time.Duration
+}
+
+// Identify implement interface TransportIdentifier
+func (sf TLSClientCertIdentifier) Identify(conn net.Conn) (map[string]string, bool) {
+	tc, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil, false
+	}
+	timeout := sf.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = defaultTLSHandshakeTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := tc.HandshakeContext(ctx); err != nil {
+		return nil, false
+	}
+	certs := tc.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, false
+	}
+	username := certs[0].Subject.CommonName
+	if sf.Username != nil {
+		username = sf.Username(certs[0])
+	}
+	return map[string]string{
+		"tls_subject": certs[0].Subject.String(),
+		"username":    username,
+	}, true
+}
+
+// SourceIPIdentifier identifies clients connecting from the allowed
+// networks, with identity key "source_ip".
+type SourceIPIdentifier struct {
+	Networks []*net.IPNet
+}
+
+// Identify implement interface TransportIdentifier
+func (sf SourceIPIdentifier) Identify(conn net.Conn) (map[string]string, bool) {
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return nil, false
+	}
+	for _, n := range sf.Networks {
+		if n.Contains(addr.IP) {
+			return map[string]string{"source_ip": addr.IP.String()}, true
+		}
+	}
+	return nil, false
+}
+
+// AuthPolicy is how a CompositeAuthenticator combines its factors
+type AuthPolicy uint8
+
+// auth policy defined
+const (
+	// AuthRequireAll requires the transport identity and the method
+	AuthRequireAll AuthPolicy = iota
+	// AuthRequireAny requires the transport identity or the method.
+	// An identified client which offers "no auth" skips the method.
+	AuthRequireAny
+)
+
+// CompositeAuthenticator combines a transport identity with a SOCKS
+// authentication method into one AuthContext, for policies such as
+// "client certificate and password" or "allowed source IP or password".
+// When both factors are used, an identity key present in both, such as
+// "username", must have the same value or the session is rejected.
+// The last reply of the method is held back until the identities are
+// compared; a VER|STATUS success reply, such as the user/pass one, is
+// turned into a failure on a mismatch.
+type CompositeAuthenticator struct {
+	Transport TransportIdentifier
+	Method    Authenticator
+	Policy    AuthPolicy
+}
+
+// GetCode implement interface Authenticator
+func (sf CompositeAuthenticator) GetCode() uint8 { return sf.Method.GetCode() }
+
+// Authenticate implement interface Authenticator, without a connection
+// there is no transport identity.
+func (sf CompositeAuthenticator) Authenticate(reader io.Reader, writer io.Writer, userAddr string) (*AuthContext, error) {
+	if sf.Policy == AuthRequireAll {
+		writer.Write([]byte{statute.VersionSocks5, statute.MethodNoAcceptable}) //nolint: errcheck
+		return nil, statute.ErrNoSupportedAuth
+	}
+	return sf.Method.Authenticate(reader, writer, userAddr)
+}
+
+// AuthenticateConn implement interface ConnAuthenticator
+func (sf CompositeAuthenticator) AuthenticateConn(conn net.Conn, reader io.Reader, userAddr string, methods []byte) (*AuthContext, error) {
+	identity, identified := sf.Transport.Identify(conn)
+	offered := func(code uint8) bool {
+		for _, m := range methods {
+			if m == code {
+				return true
+			}
+		}
+		return false
+	}
+
+	if sf.Policy == AuthRequireAll && !identified {
+		return nil, ErrAuthNotApplicable
+	}
+	if sf.Policy == AuthRequireAny && identified && offered(statute.MethodNoAuth) {
+		if _, err := conn.Write([]byte{statute.VersionSocks5, statute.MethodNoAuth}); err != nil {
+			return nil, err
+		}
+		return &AuthContext{statute.MethodNoAuth, identity}, nil
+	}
+	if !offered(sf.Method.GetCode()) {
+		return nil, ErrAuthNotApplicable
+	}
+
+	// the method's reply is written once it reads again, or the identities agree
+	hw := &holdWriter{w: conn}
+	authContext, err := sf.Method.Authenticate(flushReader{reader, hw}, hw, userAddr)
+	if err != nil {
+		if ferr := hw.flush(); ferr != nil {
+			return nil, ferr
+		}
+		return nil, err
+	}
+	payload := make(map[string]string, len(authContext.Payload)+len(identity))
+	for k, v := range authContext.Payload {
+		payload[k] = v
+	}
+	for k, v := range identity {
+		if have, ok := payload[k]; ok && have != v {
+			if len(hw.held) == 2 && hw.held[1] == statute.AuthSuccess {
+				conn.Write([]byte{hw.held[0], statute.AuthFailure}) //nolint: errcheck
+			}
+			return nil, ErrIdentityMismatch
+		}
+		payload[k] = v
+	}
+	if err := hw.flush(); err != nil {
+		return nil, err
+	}
+	return &AuthContext{authContext.Method, payload}, nil
+}
+
+// holdWriter holds back the last write, until flushed
+type holdWriter struct {
+	w    io.Writer
+	held []byte
+}
+
+func (sf *holdWriter) Write(b []byte) (int, error) {
+	if err := sf.flush(); err != nil {
+		return 0, err
+	}
+	sf.held = append(sf.held[:0], b...)
+	return len(b), nil
+}
+
+func (sf *holdWriter) flush() error {
+	if len(sf.held) == 0 {
+		return nil
+	}
+	_, err := sf.w.Write(sf.held)
+	sf.held = sf.held[:0]
+	return err
+}
+
+// flushReader flushes the held write before reading, as the peer
+// may wait for it to answer
+type flushReader struct {
+	r  io.Reader
+	hw *holdWriter
+}
+
+func (sf flushReader) Read(b []byte) (int, error) {
+	if err := sf.hw.flush(); err != nil {
+		return 0, err
+	}
+	return sf.r.Read(b)
+}
@@ -0,0 +1,180 @@
+package socks5
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/things-go/go-socks5/statute"
+)
+
+// tcpPair returns the server and client side of a loopback connection
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	server, err := l.Accept()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return server, client
+}
+
+func selfSignedCert(t *testing.T, cn string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// userPass writes the client's user/pass request and returns the replies
+func userPass(t *testing.T, conn net.Conn, user, pass string) <-chan []byte {
+	replies := make(chan []byte, 1)
+	go func() {
+		req := statute.NewUserPassRequest(statute.UserPassAuthVersion, []byte(user), []byte(pass))
+		conn.Write(req.Bytes()) //nolint: errcheck
+		b := make([]byte, 4)
+		n, _ := io.ReadFull(conn, b)
+		replies <- b[:n]
+	}()
+	return replies
+}
+
+func TestCompositeAuthenticator_CertAndPassword(t *testing.T) {
+	cert := selfSignedCert(t, "foo")
+	pool := x509.NewCertPool()
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	pool.AddCert(leaf)
+
+	s := NewServer(WithAuthMethods([]Authenticator{CompositeAuthenticator{
+		Transport: TLSClientCertIdentifier{},
+		Method:    UserPassAuthenticator{StaticCredentials{"foo": "bar", "baz": "qux"}},
+		Policy:    AuthRequireAll,
+	}}))
+
+	handshake := func(t *testing.T, withCert bool) (*tls.Conn, *tls.Conn) {
+		server, client := tcpPair(t)
+		sc := tls.Server(server, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.VerifyClientCertIfGiven,
+			ClientCAs:    pool,
+			MinVersion:   tls.VersionTLS12,
+		})
+		ccfg := &tls.Config{RootCAs: pool, ServerName: "foo", MinVersion: tls.VersionTLS12}
+		if withCert {
+			ccfg.Certificates = []tls.Certificate{cert}
+		}
+		cc := tls.Client(client, ccfg)
+		go cc.Handshake() //nolint: errcheck
+		return sc, cc
+	}
+
+	t.Run("both factors", func(t *testing.T) {
+		sc, cc := handshake(t, true)
+		replies := userPass(t, cc, "foo", "bar")
+		ctx, err := s.authenticate(sc, bufio.NewReader(sc), "", []byte{statute.MethodUserPassAuth})
+		require.NoError(t, err)
+		assert.Equal(t, statute.MethodUserPassAuth, ctx.Method)
+		assert.Equal(t, "foo", ctx.Payload["username"])
+		assert.Equal(t, "CN=foo", ctx.Payload["tls_subject"])
+		assert.Equal(t, []byte{statute.VersionSocks5, statute.MethodUserPassAuth, statute.UserPassAuthVersion, statute.AuthSuccess}, <-replies)
+	})
+	t.Run("identities disagree", func(t *testing.T) {
+		sc, cc := handshake(t, true)
+		replies := userPass(t, cc, "baz", "qux")
+		_, err := s.authenticate(sc, bufio.NewReader(sc), "", []byte{statute.MethodUserPassAuth})
+		require.ErrorIs(t, err, ErrIdentityMismatch)
+		sc.Close()
+		assert.Equal(t, []byte{statute.VersionSocks5, statute.MethodUserPassAuth, statute.UserPassAuthVersion, statute.AuthFailure}, <-replies)
+	})
+	t.Run("no certificate", func(t *testing.T) {
+		sc, cc := handshake(t, false)
+		replies := make(chan []byte, 1)
+		go func() {
+			b := make([]byte, 2)
+			n, _ := io.ReadFull(cc, b)
+			replies <- b[:n]
+		}()
+		_, err := s.authenticate(sc, bufio.NewReader(sc), "", []byte{statute.MethodUserPassAuth})
+		require.ErrorIs(t, err, statute.ErrNoSupportedAuth)
+		assert.Equal(t, []byte{statute.VersionSocks5, statute.MethodNoAcceptable}, <-replies)
+	})
+}
+
+func TestTLSClientCertIdentifier_HandshakeTimeout(t *testing.T) {
+	server, _ := tcpPair(t)
+	sc := tls.Server(server, &tls.Config{Certificates: []tls.Certificate{selfSignedCert(t, "foo")}, MinVersion: tls.VersionTLS12})
+
+	start := time.Now()
+	_, ok := TLSClientCertIdentifier{HandshakeTimeout: 50 * time.Millisecond}.Identify(sc)
+	assert.False(t, ok)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestCompositeAuthenticator_SourceIPOrPassword(t *testing.T) {
+	_, loopback, err := net.ParseCIDR("127.0.0.0/8")
+	require.NoError(t, err)
+	_, other, err := net.ParseCIDR("192.0.2.0/24")
+	require.NoError(t, err)
+	newServer := func(n *net.IPNet) *Server {
+		return NewServer(WithAuthMethods([]Authenticator{CompositeAuthenticator{
+			Transport: SourceIPIdentifier{Networks: []*net.IPNet{n}},
+			Method:    UserPassAuthenticator{StaticCredentials{"foo": "bar"}},
+			Policy:    AuthRequireAny,
+		}}))
+	}
+	methods := []byte{statute.MethodNoAuth, statute.MethodUserPassAuth}
+
+	t.Run("allowed source", func(t *testing.T) {
+		server, client := tcpPair(t)
+		ctx, err := newServer(loopback).authenticate(server, bufio.NewReader(server), "", methods)
+		require.NoError(t, err)
+		assert.Equal(t, statute.MethodNoAuth, ctx.Method)
+		assert.Equal(t, "127.0.0.1", ctx.Payload["source_ip"])
+		b := make([]byte, 2)
+		_, err = io.ReadFull(client, b)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{statute.VersionSocks5, statute.MethodNoAuth}, b)
+	})
+	t.Run("password", func(t *testing.T) {
+		server, client := tcpPair(t)
+		replies := userPass(t, client, "foo", "bar")
+		ctx, err := newServer(other).authenticate(server, bufio.NewReader(server), "", methods)
+		require.NoError(t, err)
+		assert.Equal(t, statute.MethodUserPassAuth, ctx.Method)
+		assert.Equal(t, "foo", ctx.Payload["username"])
+		assert.NotContains(t, ctx.Payload, "source_ip")
+		<-replies
+	})
+	t.Run("neither", func(t *testing.T) {
+		server, _ := tcpPair(t)
+		_, err := newServer(other).authenticate(server, bufio.NewReader(server), "", []byte{statute.MethodNoAuth})
+		require.ErrorIs(t, err, statute.ErrNoSupportedAuth)
+	})
+}
@@ -6,6 +6,14 @@ type CredentialStore interface {
 	Valid(user, password, userAddr string) bool
 }
 
+// IdentityCredentialStore is a CredentialStore which also knows the identity
+// behind valid credentials. UserPassAuthenticator merges the returned entries
+// into the AuthContext payload.
+type IdentityCredentialStore interface {
+	CredentialStore
+	Identity(user, password, userAddr string) (map[string]string, bool)
+}
+
 // StaticCredentials enables using a map directly as a credential store
 type StaticCredentials map[string]string
 
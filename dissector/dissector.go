@@ -0,0 +1,293 @@
+// Package dissector decodes raw captures of a SOCKS5 conversation into a
+// structured, human-readable trace, for logging and debugging.
+package dissector
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/things-go/go-socks5/statute"
+)
+
+// Direction of a message
+type Direction uint8
+
+// direction defined
+const (
+	ClientToServer Direction = iota
+	ServerToClient
+)
+
+// String implement interface fmt.Stringer
+func (d Direction) String() string {
+	if d == ServerToClient {
+		return "S->C"
+	}
+	return "C->S"
+}
+
+// Field is a decoded field of a message
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Message is a decoded SOCKS message
+type Message struct {
+	Direction Direction
+	// Name of the message, for example "method request"
+	Name string
+	// Fields in wire order
+	Fields []Field
+	// Length on the wire in bytes, zero if not known
+	Length int
+}
+
+// String implement interface fmt.Stringer
+func (m Message) String() string {
+	var b strings.Builder
+	b.WriteString(m.Direction.String())
+	b.WriteByte(' ')
+	b.WriteString(m.Name)
+	for i, f := range m.Fields {
+		if i == 0 {
+			b.WriteByte(':')
+		}
+		b.WriteByte(' ')
+		b.WriteString(f.Name)
+		b.WriteByte('=')
+		b.WriteString(f.Value)
+	}
+	return b.String()
+}
+
+// Trace is a decoded conversation
+type Trace struct {
+	Messages []Message
+	// Err is the error which stopped decoding, nil if the capture
+	// was decoded completely or just ended early.
+	Err error
+}
+
+// String implement interface fmt.Stringer, one message per line
+func (t Trace) String() string {
+	var b strings.Builder
+	for _, m := range t.Messages {
+		b.WriteString(m.String())
+		b.WriteByte('\n')
+	}
+	if t.Err != nil {
+		fmt.Fprintf(&b, "error: %v\n", t.Err)
+	}
+	return b.String()
+}
+
+// Conversation decodes the client to server and server to client byte
+// streams of one SOCKS5 tcp conversation. Bytes following the reply are
+// reported as payload. A capture which ends early is not an error.
+func Conversation(client, server []byte) Trace {
+	var t Trace
+	cr, sr := bytes.NewReader(client), bytes.NewReader(server)
+
+	// decode runs parse on r and appends the message it builds
+	decode := func(r *bytes.Reader, parse func() (Message, error)) bool {
+		if r.Len() == 0 {
+			return false
+		}
+		n := r.Len()
+		m, err := parse()
+		if err != nil {
+			// running out of bytes is a truncated capture, not an error
+			if r.Len() > 0 && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Err = err
+			}
+			return false
+		}
+		m.Length = n - r.Len()
+		t.Messages = append(t.Messages, m)
+		return true
+	}
+
+	if !decode(cr, func() (Message, error) {
+		mr, err := statute.ParseMethodRequest(cr)
+		return MethodRequest(mr), err
+	}) {
+		return t
+	}
+	var method byte
+	if !decode(sr, func() (Message, error) {
+		mr, err := statute.ParseMethodReply(sr)
+		method = mr.Method
+		return MethodReply(mr), err
+	}) {
+		return t
+	}
+	switch method {
+	case statute.MethodNoAuth:
+	case statute.MethodUserPassAuth:
+		if !decode(cr, func() (Message, error) {
+			upr, err := statute.ParseUserPassRequest(cr)
+			return UserPassRequest(upr), err
+		}) {
+			return t
+		}
+		status := statute.AuthFailure
+		if !decode(sr, func() (Message, error) {
+			upr, err := statute.ParseUserPassReply(sr)
+			status = upr.Status
+			return UserPassReply(upr), err
+		}) || status != statute.AuthSuccess {
+			return t
+		}
+	default:
+		// no acceptable method, or a sub-negotiation we can not decode
+		return t
+	}
+
+	if !decode(cr, func() (Message, error) {
+		req, err := statute.ParseRequest(cr)
+		return Request(req), err
+	}) {
+		return t
+	}
+	if !decode(sr, func() (Message, error) {
+		rep, err := statute.ParseReply(sr)
+		return Reply(rep), err
+	}) {
+		return t
+	}
+
+	if n := cr.Len(); n > 0 {
+		t.Messages = append(t.Messages, payload(ClientToServer, n))
+	}
+	if n := sr.Len(); n > 0 {
+		t.Messages = append(t.Messages, payload(ServerToClient, n))
+	}
+	return t
+}
+
+func payload(dir Direction, n int) Message {
+	return Message{
+		Direction: dir,
+		Name:      "payload",
+		Fields:    []Field{{"bytes", strconv.Itoa(n)}},
+		Length:    n,
+	}
+}
+
+// MethodRequest describes a method request
+func MethodRequest(mr statute.MethodRequest) Message {
+	methods := make([]string, 0, len(mr.Methods))
+	for _, m := range mr.Methods {
+		methods = append(methods, MethodName(m))
+	}
+	return Message{
+		Direction: ClientToServer,
+		Name:      "method request",
+		Fields: []Field{
+			{"ver", strconv.Itoa(int(mr.Ver))},
+			{"methods", "[" + strings.Join(methods, ", ") + "]"},
+		},
+	}
+}
+
+// MethodReply describes a method reply
+func MethodReply(mr statute.MethodReply) Message {
+	return Message{
+		Direction: ServerToClient,
+		Name:      "method reply",
+		Fields: []Field{
+			{"ver", strconv.Itoa(int(mr.Ver))},
+			{"method", MethodName(mr.Method)},
+		},
+	}
+}
+
+// UserPassRequest describes a user/pass request, the password is redacted
+func UserPassRequest(upr statute.UserPassRequest) Message {
+	return Message{
+		Direction: ClientToServer,
+		Name:      "user/pass request",
+		Fields: []Field{
+			{"ver", strconv.Itoa(int(upr.Ver))},
+			{"user", strconv.Quote(string(upr.User))},
+			{"password", "<redacted>"},
+		},
+	}
+}
+
+// UserPassReply describes a user/pass reply
+func UserPassReply(upr statute.UserPassReply) Message {
+	return Message{
+		Direction: ServerToClient,
+		Name:      "user/pass reply",
+		Fields: []Field{
+			{"ver", strconv.Itoa(int(upr.Ver))},
+			{"status", AuthStatusName(upr.Status)},
+		},
+	}
+}
+
+// Request describes a request
+func Request(req statute.Request) Message {
+	return Message{
+		Direction: ClientToServer,
+		Name:      "request",
+		Fields: []Field{
+			{"ver", strconv.Itoa(int(req.Version))},
+			{"cmd", CommandName(req.Command)},
+			{"atyp", AddrTypeName(req.DstAddr.AddrType)},
+			{"dst", addr(req.DstAddr)},
+		},
+	}
+}
+
+// Reply describes a reply
+func Reply(rep statute.Reply) Message {
+	return Message{
+		Direction: ServerToClient,
+		Name:      "reply",
+		Fields: []Field{
+			{"ver", strconv.Itoa(int(rep.Version))},
+			{"rep", ReplyName(rep.Response)},
+			{"atyp", AddrTypeName(rep.BndAddr.AddrType)},
+			{"bnd", addr(rep.BndAddr)},
+		},
+	}
+}
+
+// Datagram describes a udp datagram
+func Datagram(dir Direction, da statute.Datagram) Message {
+	return Message{
+		Direction: dir,
+		Name:      "udp datagram",
+		Fields: []Field{
+			{"frag", strconv.Itoa(int(da.Frag))},
+			{"atyp", AddrTypeName(da.DstAddr.AddrType)},
+			{"dst", addr(da.DstAddr)},
+			{"bytes", strconv.Itoa(len(da.Data))},
+		},
+	}
+}
+
+// DecodeDatagram decodes a raw udp datagram
+func DecodeDatagram(dir Direction, b []byte) (Message, error) {
+	da, err := statute.ParseDatagram(b)
+	if err != nil {
+		return Message{}, err
+	}
+	m := Datagram(dir, da)
+	m.Length = len(b)
+	return m, nil
+}
+
+func addr(as statute.AddrSpec) string {
+	if as.AddrType == statute.ATYPDomain {
+		return strconv.Quote(as.FQDN) + ":" + strconv.Itoa(as.Port)
+	}
+	return as.String()
+}
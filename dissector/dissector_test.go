@@ -0,0 +1,82 @@
+package dissector
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/things-go/go-socks5/statute"
+)
+
+func TestConversation(t *testing.T) {
+	client := new(bytes.Buffer)
+	client.Write(statute.NewMethodRequest(statute.VersionSocks5,
+		[]byte{statute.MethodNoAuth, statute.MethodUserPassAuth}).Bytes())
+	client.Write(statute.NewUserPassRequest(statute.UserPassAuthVersion, []byte("foo"), []byte("secret")).Bytes())
+	client.Write(statute.Request{
+		Version: statute.VersionSocks5,
+		Command: statute.CommandConnect,
+		DstAddr: statute.AddrSpec{FQDN: "example.com", Port: 443, AddrType: statute.ATYPDomain},
+	}.Bytes())
+	client.WriteString("hello")
+
+	server := new(bytes.Buffer)
+	server.Write([]byte{statute.VersionSocks5, statute.MethodUserPassAuth})
+	server.Write([]byte{statute.UserPassAuthVersion, statute.AuthSuccess})
+	server.Write(statute.Reply{
+		Version:  statute.VersionSocks5,
+		Response: statute.RepSuccess,
+		BndAddr:  statute.AddrSpec{IP: net.IPv4(10, 0, 0, 1), Port: 1080, AddrType: statute.ATYPIPv4},
+	}.Bytes())
+
+	trace := Conversation(client.Bytes(), server.Bytes())
+	require.NoError(t, trace.Err)
+	assert.Equal(t, ""+
+		"C->S method request: ver=5 methods=[no-auth, user/pass]\n"+
+		"S->C method reply: ver=5 method=user/pass\n"+
+		"C->S user/pass request: ver=1 user=\"foo\" password=<redacted>\n"+
+		"S->C user/pass reply: ver=1 status=success\n"+
+		"C->S request: ver=5 cmd=connect atyp=domain dst=\"example.com\":443\n"+
+		"S->C reply: ver=5 rep=succeeded atyp=ipv4 bnd=10.0.0.1:1080\n"+
+		"C->S payload: bytes=5\n",
+		trace.String())
+	assert.NotContains(t, trace.String(), "secret")
+	assert.Equal(t, 4, trace.Messages[0].Length)
+}
+
+func TestConversation_Truncated(t *testing.T) {
+	trace := Conversation([]byte{statute.VersionSocks5, 1, statute.MethodNoAuth, statute.VersionSocks5, 1}, []byte{
+		statute.VersionSocks5, statute.MethodNoAuth,
+	})
+	require.NoError(t, trace.Err)
+	require.Len(t, trace.Messages, 2)
+
+	trace = Conversation([]byte{statute.VersionSocks5, 1, statute.MethodNoAuth, 4, 1, 0, 1, 0, 0, 0, 0, 0, 0}, []byte{
+		statute.VersionSocks5, statute.MethodNoAuth,
+	})
+	require.Error(t, trace.Err)
+	assert.Contains(t, trace.String(), "error: ")
+}
+
+func TestDecodeDatagram(t *testing.T) {
+	da, err := statute.NewDatagram("127.0.0.1:53", []byte("query"))
+	require.NoError(t, err)
+
+	m, err := DecodeDatagram(ClientToServer, da.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, "C->S udp datagram: frag=0 atyp=ipv4 dst=127.0.0.1:53 bytes=5", m.String())
+
+	_, err = DecodeDatagram(ClientToServer, []byte{0, 0})
+	require.Error(t, err)
+}
+
+func TestNames(t *testing.T) {
+	assert.Equal(t, "host unreachable", ReplyName(statute.RepHostUnreachable))
+	assert.Equal(t, "unassigned(0x09)", ReplyName(9))
+	assert.Equal(t, "private(0x80)", MethodName(0x80))
+	assert.Equal(t, "bind", CommandName(statute.CommandBind))
+	assert.Equal(t, "ipv6", AddrTypeName(statute.ATYPIPv6))
+}
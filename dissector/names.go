@@ -0,0 +1,84 @@
+package dissector
+
+import (
+	"fmt"
+
+	"github.com/things-go/go-socks5/statute"
+)
+
+// MethodName returns the name of an authentication method
+func MethodName(method byte) string {
+	switch method {
+	case statute.MethodNoAuth:
+		return "no-auth"
+	case statute.MethodGSSAPI:
+		return "gssapi"
+	case statute.MethodUserPassAuth:
+		return "user/pass"
+	case statute.MethodNoAcceptable:
+		return "no-acceptable"
+	}
+	if method >= 0x80 {
+		return fmt.Sprintf("private(%#02x)", method)
+	}
+	return fmt.Sprintf("unassigned(%#02x)", method)
+}
+
+// CommandName returns the name of a request command
+func CommandName(cmd byte) string {
+	switch cmd {
+	case statute.CommandConnect:
+		return "connect"
+	case statute.CommandBind:
+		return "bind"
+	case statute.CommandAssociate:
+		return "associate"
+	}
+	return fmt.Sprintf("unknown(%#02x)", cmd)
+}
+
+// AddrTypeName returns the name of an address type
+func AddrTypeName(atyp byte) string {
+	switch atyp {
+	case statute.ATYPIPv4:
+		return "ipv4"
+	case statute.ATYPDomain:
+		return "domain"
+	case statute.ATYPIPv6:
+		return "ipv6"
+	}
+	return fmt.Sprintf("unknown(%#02x)", atyp)
+}
+
+// ReplyName returns the name of a reply code
+func ReplyName(rep uint8) string {
+	switch rep {
+	case statute.RepSuccess:
+		return "succeeded"
+	case statute.RepServerFailure:
+		return "general server failure"
+	case statute.RepRuleFailure:
+		return "connection not allowed by ruleset"
+	case statute.RepNetworkUnreachable:
+		return "network unreachable"
+	case statute.RepHostUnreachable:
+		return "host unreachable"
+	case statute.RepConnectionRefused:
+		return "connection refused"
+	case statute.RepTTLExpired:
+		return "ttl expired"
+	case statute.RepCommandNotSupported:
+		return "command not supported"
+	case statute.RepAddrTypeNotSupported:
+		return "address type not supported"
+	}
+	return fmt.Sprintf("unassigned(%#02x)", rep)
+}
+
+// AuthStatusName returns the name of a user/pass authentication status
+func AuthStatusName(status byte) string {
+	if status == statute.AuthSuccess {
+		return "success"
+	}
+	return fmt.Sprintf("failure(%#02x)", status)
+}
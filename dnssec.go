@@ -0,0 +1,720 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ErrDNSSECBogus is returned when an answer fails DNSSEC validation:
+// missing, expired or invalid signatures, or a broken chain of trust.
+var ErrDNSSECBogus = errors.New("dnssec: bogus response")
+
+// dnssec record types and algorithms defined
+const (
+	dnsTypeDS     = dnsmessage.Type(43)
+	dnsTypeRRSIG  = dnsmessage.Type(46)
+	dnsTypeDNSKEY = dnsmessage.Type(48)
+
+	dnssecAlgRSASHA256       = 8
+	dnssecAlgRSASHA512       = 10
+	dnssecAlgECDSAP256SHA256 = 13
+	dnssecAlgECDSAP384SHA384 = 14
+	dnssecAlgED25519         = 15
+
+	dnssecDigestSHA256 = 2
+	dnssecDigestSHA384 = 4
+)
+
+// TrustAnchor is a DS record of a zone trusted without validation
+type TrustAnchor struct {
+	Zone       string
+	KeyTag     uint16
+	Algorithm  uint8
+	DigestType uint8
+	Digest     []byte
+}
+
+// RootTrustAnchor is the root zone KSK-2017 trust anchor published by IANA
+var RootTrustAnchor = TrustAnchor{
+	Zone:       ".",
+	KeyTag:     20326,
+	Algorithm:  dnssecAlgRSASHA256,
+	DigestType: dnssecDigestSHA256,
+	Digest:     mustHex("e06d44b80b8f1d39a95c0b0d7c65d08458e880409bbc683457104237c7f8ec8d"),
+}
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// DNSSECResolver is a NameResolver which validates the A/AAAA answers of
+// an upstream recursive resolver with DNSSEC, along the chain of trust from
+// the trust anchors. Answers which can not be validated fail with
+// ErrDNSSECBogus, so connects to spoofed names are refused.
+//
+// Proofs of non-existence (NSEC/NSEC3) are not validated: unsigned
+// delegations and wildcard expanded answers are treated as bogus.
+type DNSSECResolver struct {
+	// Server is the upstream recursive resolver, "host:port"
+	Server string
+	// Anchors the trust anchors, defaults to RootTrustAnchor
+	Anchors []TrustAnchor
+	// Timeout of a query, defaults to 5 seconds
+	Timeout time.Duration
+
+	now  func() time.Time
+	mu   sync.Mutex
+	keys map[string]dnssecZoneKeys
+}
+
+type dnssecZoneKeys struct {
+	keys    []dnsRR
+	expires time.Time
+}
+
+// NewDNSSECResolver new DNSSEC validating resolver using the upstream server
+func NewDNSSECResolver(server string, anchors ...TrustAnchor) *DNSSECResolver {
+	return &DNSSECResolver{
+		Server:  server,
+		Anchors: anchors,
+	}
+}
+
+// Resolve implement interface NameResolver
+func (sf *DNSSECResolver) Resolve(ctx context.Context, name string) (context.Context, net.IP, error) {
+	qname := canonicalDNSName(name)
+	var errs []error
+	for _, typ := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		rrs, err := sf.lookup(ctx, qname, typ)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if len(rrs) != 0 {
+			return ctx, net.IP(rrs[0].data), nil
+		}
+	}
+	for _, err := range errs {
+		if errors.Is(err, ErrDNSSECBogus) {
+			return ctx, nil, err
+		}
+	}
+	if len(errs) != 0 {
+		return ctx, nil, errs[0]
+	}
+	return ctx, nil, fmt.Errorf("dnssec: no address for %s", name)
+}
+
+// lookup returns the validated records of the type, following CNAMEs
+func (sf *DNSSECResolver) lookup(ctx context.Context, qname string, typ dnsmessage.Type) ([]dnsRR, error) {
+	answers, err := sf.exchange(ctx, qname, typ)
+	if err != nil {
+		return nil, err
+	}
+
+	name := qname
+	for i := 0; i < 8; i++ {
+		if rrs := selectRRset(answers, name, dnsmessage.TypeCNAME); len(rrs) != 0 {
+			if err := sf.verifyRRset(ctx, rrs, answers, 0); err != nil {
+				return nil, err
+			}
+			name = dnsNameFromWire(rrs[0].data)
+			continue
+		}
+		rrs := selectRRset(answers, name, typ)
+		if len(rrs) == 0 {
+			return nil, nil
+		}
+		if err := sf.verifyRRset(ctx, rrs, answers, 0); err != nil {
+			return nil, err
+		}
+		return rrs, nil
+	}
+	return nil, fmt.Errorf("dnssec: too many CNAMEs for %s", qname)
+}
+
+// verifyRRset verifies the RRset with one of its signatures among answers,
+// made by a zone key of the signer zone.
+func (sf *DNSSECResolver) verifyRRset(ctx context.Context, rrs, answers []dnsRR, depth int) error {
+	owner, typ := rrs[0].name, rrs[0].typ
+	var lastErr error = fmt.Errorf("%w: no signature for %s %v", ErrDNSSECBogus, owner, typ)
+	for _, rr := range selectRRset(answers, owner, dnsTypeRRSIG) {
+		sig, err := parseRRSIG(rr.data)
+		if err != nil || sig.typeCovered != typ || !isDNSSubdomain(owner, sig.signer) {
+			continue
+		}
+		if err := sig.check(owner, sf.timeNow()); err != nil {
+			lastErr = err
+			continue
+		}
+		keys, err := sf.zoneKeys(ctx, sig.signer, depth+1)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if err := sig.verifyWith(keys, rrs); err != nil {
+			lastErr = err
+			continue
+		}
+		return nil
+	}
+	return lastErr
+}
+
+// zoneKeys returns the validated zone keys of the zone
+func (sf *DNSSECResolver) zoneKeys(ctx context.Context, zone string, depth int) ([]dnsRR, error) {
+	if depth > 16 {
+		return nil, fmt.Errorf("%w: chain of trust too long at %s", ErrDNSSECBogus, zone)
+	}
+	sf.mu.Lock()
+	if zk, ok := sf.keys[zone]; ok && sf.timeNow().Before(zk.expires) {
+		sf.mu.Unlock()
+		return zk.keys, nil
+	}
+	sf.mu.Unlock()
+
+	answers, err := sf.exchange(ctx, zone, dnsTypeDNSKEY)
+	if err != nil {
+		return nil, err
+	}
+	dnskeys := selectRRset(answers, zone, dnsTypeDNSKEY)
+	if len(dnskeys) == 0 {
+		return nil, fmt.Errorf("%w: no DNSKEY for %s", ErrDNSSECBogus, zone)
+	}
+	ds, err := sf.delegationSigners(ctx, zone, depth)
+	if err != nil {
+		return nil, err
+	}
+
+	// the key set must be signed by a key matching a DS
+	var trusted []dnsRR
+	for _, key := range dnskeys {
+		for _, d := range ds {
+			if d.matches(zone, key.data) {
+				trusted = append(trusted, key)
+				break
+			}
+		}
+	}
+	if len(trusted) == 0 {
+		return nil, fmt.Errorf("%w: no DNSKEY of %s matches its DS", ErrDNSSECBogus, zone)
+	}
+	err = fmt.Errorf("%w: no valid DNSKEY signature for %s", ErrDNSSECBogus, zone)
+	for _, rr := range selectRRset(answers, zone, dnsTypeRRSIG) {
+		sig, perr := parseRRSIG(rr.data)
+		if perr != nil || sig.typeCovered != dnsTypeDNSKEY || sig.signer != zone {
+			continue
+		}
+		if err = sig.check(zone, sf.timeNow()); err != nil {
+			continue
+		}
+		if err = sig.verifyWith(trusted, dnskeys); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var zoneKeys []dnsRR
+	ttl := dnskeys[0].ttl
+	for _, key := range dnskeys {
+		// the zone key flag
+		if len(key.data) >= 4 && key.data[0]&0x01 != 0 {
+			zoneKeys = append(zoneKeys, key)
+		}
+		if key.ttl < ttl {
+			ttl = key.ttl
+		}
+	}
+	sf.mu.Lock()
+	if sf.keys == nil {
+		sf.keys = make(map[string]dnssecZoneKeys)
+	}
+	sf.keys[zone] = dnssecZoneKeys{zoneKeys, sf.timeNow().Add(time.Duration(ttl) * time.Second)}
+	sf.mu.Unlock()
+	return zoneKeys, nil
+}
+
+// delegationSigners returns the trust anchors of the zone, or else its DS
+// records validated in the parent zone.
+func (sf *DNSSECResolver) delegationSigners(ctx context.Context, zone string, depth int) ([]TrustAnchor, error) {
+	var ds []TrustAnchor
+	for _, a := range sf.anchors() {
+		if canonicalDNSName(a.Zone) == zone {
+			ds = append(ds, a)
+		}
+	}
+	if len(ds) != 0 {
+		return ds, nil
+	}
+	if zone == "." {
+		return nil, fmt.Errorf("%w: no trust anchor", ErrDNSSECBogus)
+	}
+
+	answers, err := sf.exchange(ctx, zone, dnsTypeDS)
+	if err != nil {
+		return nil, err
+	}
+	rrs := selectRRset(answers, zone, dnsTypeDS)
+	if len(rrs) == 0 {
+		return nil, fmt.Errorf("%w: no DS for %s", ErrDNSSECBogus, zone)
+	}
+	// the DS set must be signed in a parent zone
+	var parent []dnsRR
+	for _, rr := range selectRRset(answers, zone, dnsTypeRRSIG) {
+		if sig, err := parseRRSIG(rr.data); err == nil && sig.signer != zone {
+			parent = append(parent, rr)
+		}
+	}
+	if err := sf.verifyRRset(ctx, rrs, append(rrs[:len(rrs):len(rrs)], parent...), depth); err != nil {
+		return nil, err
+	}
+	for _, rr := range rrs {
+		if len(rr.data) < 5 {
+			continue
+		}
+		ds = append(ds, TrustAnchor{
+			Zone:       zone,
+			KeyTag:     binary.BigEndian.Uint16(rr.data),
+			Algorithm:  rr.data[2],
+			DigestType: rr.data[3],
+			Digest:     rr.data[4:],
+		})
+	}
+	return ds, nil
+}
+
+func (sf *DNSSECResolver) anchors() []TrustAnchor {
+	if len(sf.Anchors) == 0 {
+		return []TrustAnchor{RootTrustAnchor}
+	}
+	return sf.Anchors
+}
+
+func (sf *DNSSECResolver) timeNow() time.Time {
+	if sf.now != nil {
+		return sf.now()
+	}
+	return time.Now()
+}
+
+// exchange queries the upstream server with the DNSSEC OK bit,
+// over udp and over tcp if the answer was truncated.
+func (sf *DNSSECResolver) exchange(ctx context.Context, name string, typ dnsmessage.Type) ([]dnsRR, error) {
+	timeout := sf.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+	var idb [2]byte
+	if _, err := rand.Read(idb[:]); err != nil {
+		return nil, err
+	}
+	id := binary.BigEndian.Uint16(idb[:])
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	b.EnableCompression()
+	b.StartQuestions()                                                                   //nolint: errcheck
+	b.Question(dnsmessage.Question{Name: qname, Type: typ, Class: dnsmessage.ClassINET}) //nolint: errcheck
+	b.StartAdditionals()                                                                 //nolint: errcheck
+	var opt dnsmessage.ResourceHeader
+	opt.SetEDNS0(4096, dnsmessage.RCodeSuccess, true) //nolint: errcheck
+	b.OPTResource(opt, dnsmessage.OPTResource{})      //nolint: errcheck
+	query, err := b.Finish()
+	if err != nil {
+		return nil, err
+	}
+
+	var d net.Dialer
+	msg, err := dnsRoundTrip(ctx, &d, "udp", sf.Server, query)
+	if err != nil {
+		return nil, err
+	}
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil {
+		return nil, err
+	}
+	if h.Truncated {
+		if msg, err = dnsRoundTrip(ctx, &d, "tcp", sf.Server, query); err != nil {
+			return nil, err
+		}
+		if h, err = p.Start(msg); err != nil {
+			return nil, err
+		}
+	}
+	if h.ID != id || !h.Response {
+		return nil, errors.New("dnssec: mismatched response")
+	}
+	if h.RCode != dnsmessage.RCodeSuccess && h.RCode != dnsmessage.RCodeNameError {
+		return nil, fmt.Errorf("dnssec: %s %v query failed, %v", name, typ, h.RCode)
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, err
+	}
+	resources, err := p.AllAnswers()
+	if err != nil {
+		return nil, err
+	}
+
+	rrs := make([]dnsRR, 0, len(resources))
+	for _, r := range resources {
+		rr := dnsRR{
+			name:  canonicalDNSName(r.Header.Name.String()),
+			typ:   r.Header.Type,
+			class: r.Header.Class,
+			ttl:   r.Header.TTL,
+		}
+		switch body := r.Body.(type) {
+		case *dnsmessage.AResource:
+			rr.data = append([]byte(nil), body.A[:]...)
+		case *dnsmessage.AAAAResource:
+			rr.data = append([]byte(nil), body.AAAA[:]...)
+		case *dnsmessage.CNAMEResource:
+			rr.data = dnsNameToWire(canonicalDNSName(body.CNAME.String()))
+		case *dnsmessage.UnknownResource:
+			rr.data = body.Data
+		default:
+			continue
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs, nil
+}
+
+func dnsRoundTrip(ctx context.Context, d *net.Dialer, network, server string, query []byte) ([]byte, error) {
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline) //nolint: errcheck
+	}
+
+	if network == "udp" {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		msg := make([]byte, 4096)
+		n, err := conn.Read(msg)
+		if err != nil {
+			return nil, err
+		}
+		return msg[:n], nil
+	}
+
+	framed := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(framed, uint16(len(query)))
+	copy(framed[2:], query)
+	if _, err := conn.Write(framed); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(conn, framed[:2]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(framed))
+	if _, err := io.ReadFull(conn, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// dnsRR is a resource record with a canonical owner name and rdata
+type dnsRR struct {
+	name  string
+	typ   dnsmessage.Type
+	class dnsmessage.Class
+	ttl   uint32
+	data  []byte
+}
+
+// selectRRset returns the records of the owner and type
+func selectRRset(rrs []dnsRR, name string, typ dnsmessage.Type) []dnsRR {
+	var set []dnsRR
+	for _, rr := range rrs {
+		if rr.name == name && rr.typ == typ {
+			set = append(set, rr)
+		}
+	}
+	return set
+}
+
+// dnsRRSIG is the RDATA of an RRSIG record (RFC 4034 section 3.1)
+type dnsRRSIG struct {
+	typeCovered dnsmessage.Type
+	algorithm   uint8
+	labels      uint8
+	origTTL     uint32
+	expiration  uint32
+	inception   uint32
+	keyTag      uint16
+	signer      string
+	// signed the RDATA without the signature
+	signed    []byte
+	signature []byte
+}
+
+func parseRRSIG(data []byte) (*dnsRRSIG, error) {
+	if len(data) < 19 {
+		return nil, errors.New("dnssec: short RRSIG")
+	}
+	sig := &dnsRRSIG{
+		typeCovered: dnsmessage.Type(binary.BigEndian.Uint16(data)),
+		algorithm:   data[2],
+		labels:      data[3],
+		origTTL:     binary.BigEndian.Uint32(data[4:]),
+		expiration:  binary.BigEndian.Uint32(data[8:]),
+		inception:   binary.BigEndian.Uint32(data[12:]),
+		keyTag:      binary.BigEndian.Uint16(data[16:]),
+	}
+	off := 18
+	for {
+		if off >= len(data) {
+			return nil, errors.New("dnssec: bad RRSIG signer name")
+		}
+		n := int(data[off])
+		if n&0xc0 != 0 {
+			return nil, errors.New("dnssec: compressed RRSIG signer name")
+		}
+		off += 1 + n
+		if n == 0 {
+			break
+		}
+	}
+	if off > len(data) {
+		return nil, errors.New("dnssec: bad RRSIG signer name")
+	}
+	sig.signer = dnsNameFromWire(data[18:off])
+	sig.signed = append(append([]byte(nil), data[:18]...), dnsNameToWire(sig.signer)...)
+	sig.signature = data[off:]
+	return sig, nil
+}
+
+// check checks the signature covers the owner name without wildcard
+// expansion, and that now is within its validity period with serial number
+// arithmetic (RFC 1982).
+func (sf *dnsRRSIG) check(owner string, now time.Time) error {
+	if int(sf.labels) != dnsLabelCount(owner) {
+		return fmt.Errorf("%w: wildcard answer for %s", ErrDNSSECBogus, owner)
+	}
+	t := uint32(now.Unix())
+	if int32(t-sf.inception) < 0 || int32(sf.expiration-t) < 0 {
+		return fmt.Errorf("%w: signature of %s %v expired", ErrDNSSECBogus, owner, sf.typeCovered)
+	}
+	return nil
+}
+
+// verifyWith verifies the signature of the RRset with one of the keys
+func (sf *dnsRRSIG) verifyWith(keys, rrs []dnsRR) error {
+	for _, key := range keys {
+		if len(key.data) < 4 || key.data[3] != sf.algorithm || dnskeyTag(key.data) != sf.keyTag {
+			continue
+		}
+		if err := sf.verify(key.data, rrs); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: invalid signature of %s %v", ErrDNSSECBogus, rrs[0].name, rrs[0].typ)
+}
+
+// verify verifies the signature of the RRset with the DNSKEY RDATA
+func (sf *dnsRRSIG) verify(dnskey []byte, rrs []dnsRR) error {
+	data := sf.signedData(rrs)
+	key := dnskey[4:]
+	switch sf.algorithm {
+	case dnssecAlgRSASHA256, dnssecAlgRSASHA512:
+		pub, err := parseDNSKeyRSA(key)
+		if err != nil {
+			return err
+		}
+		h, ch := sha256.New(), crypto.SHA256
+		if sf.algorithm == dnssecAlgRSASHA512 {
+			h, ch = sha512.New(), crypto.SHA512
+		}
+		h.Write(data) //nolint: errcheck
+		return rsa.VerifyPKCS1v15(pub, ch, h.Sum(nil), sf.signature)
+	case dnssecAlgECDSAP256SHA256, dnssecAlgECDSAP384SHA384:
+		curve, h := elliptic.P256(), hash.Hash(sha256.New())
+		if sf.algorithm == dnssecAlgECDSAP384SHA384 {
+			curve, h = elliptic.P384(), sha512.New384()
+		}
+		size := curve.Params().BitSize / 8
+		if len(key) != 2*size || len(sf.signature) != 2*size {
+			return errors.New("dnssec: bad ECDSA key or signature length")
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(key[:size]),
+			Y:     new(big.Int).SetBytes(key[size:]),
+		}
+		h.Write(data) //nolint: errcheck
+		r := new(big.Int).SetBytes(sf.signature[:size])
+		s := new(big.Int).SetBytes(sf.signature[size:])
+		if !ecdsa.Verify(pub, h.Sum(nil), r, s) {
+			return errors.New("dnssec: ECDSA verification failed")
+		}
+		return nil
+	case dnssecAlgED25519:
+		if len(key) != ed25519.PublicKeySize || !ed25519.Verify(ed25519.PublicKey(key), data, sf.signature) {
+			return errors.New("dnssec: Ed25519 verification failed")
+		}
+		return nil
+	}
+	return fmt.Errorf("dnssec: unsupported algorithm %d", sf.algorithm)
+}
+
+// signedData is the data covered by the signature (RFC 4034 section 3.1.8.1),
+// the RRs in canonical form and order.
+func (sf *dnsRRSIG) signedData(rrs []dnsRR) []byte {
+	sorted := append([]dnsRR(nil), rrs...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i].data, sorted[j].data) < 0 })
+
+	data := append([]byte(nil), sf.signed...)
+	var prev []byte
+	for i, rr := range sorted {
+		// duplicate records are only signed once
+		if i > 0 && bytes.Equal(rr.data, prev) {
+			continue
+		}
+		prev = rr.data
+		data = append(data, dnsNameToWire(rr.name)...)
+		data = append(data, byte(rr.typ>>8), byte(rr.typ), byte(rr.class>>8), byte(rr.class))
+		data = append(data, byte(sf.origTTL>>24), byte(sf.origTTL>>16), byte(sf.origTTL>>8), byte(sf.origTTL))
+		data = append(data, byte(len(rr.data)>>8), byte(len(rr.data)))
+		data = append(data, rr.data...)
+	}
+	return data
+}
+
+// parseDNSKeyRSA parses an RSA public key in the RFC 3110 format
+func parseDNSKeyRSA(key []byte) (*rsa.PublicKey, error) {
+	if len(key) < 1 {
+		return nil, errors.New("dnssec: short RSA key")
+	}
+	n, off := int(key[0]), 1
+	if n == 0 {
+		if len(key) < 3 {
+			return nil, errors.New("dnssec: short RSA key")
+		}
+		n, off = int(binary.BigEndian.Uint16(key[1:])), 3
+	}
+	if n == 0 || n > 4 || len(key) <= off+n {
+		return nil, errors.New("dnssec: bad RSA exponent")
+	}
+	e := 0
+	for _, b := range key[off : off+n] {
+		e = e<<8 | int(b)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(key[off+n:]), E: e}, nil
+}
+
+// dnskeyTag is the key tag of a DNSKEY RDATA (RFC 4034 appendix B)
+func dnskeyTag(data []byte) uint16 {
+	var ac uint32
+	for i, b := range data {
+		if i&1 == 0 {
+			ac += uint32(b) << 8
+		} else {
+			ac += uint32(b)
+		}
+	}
+	ac += ac >> 16 & 0xffff
+	return uint16(ac & 0xffff)
+}
+
+// matches reports whether the DS matches the DNSKEY RDATA of the zone
+func (sf TrustAnchor) matches(zone string, dnskey []byte) bool {
+	if len(dnskey) < 4 || dnskeyTag(dnskey) != sf.KeyTag || dnskey[3] != sf.Algorithm {
+		return false
+	}
+	var h hash.Hash
+	switch sf.DigestType {
+	case dnssecDigestSHA256:
+		h = sha256.New()
+	case dnssecDigestSHA384:
+		h = sha512.New384()
+	default:
+		return false
+	}
+	h.Write(dnsNameToWire(zone)) //nolint: errcheck
+	h.Write(dnskey)              //nolint: errcheck
+	return bytes.Equal(h.Sum(nil), sf.Digest)
+}
+
+// canonicalDNSName returns the name lowercased and fully qualified
+func canonicalDNSName(name string) string {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
+
+// dnsLabelCount returns the number of labels of a canonical name
+func dnsLabelCount(name string) int {
+	if name == "." {
+		return 0
+	}
+	return strings.Count(name, ".")
+}
+
+// isDNSSubdomain reports whether the canonical name is the zone or below it
+func isDNSSubdomain(name, zone string) bool {
+	return zone == "." || name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+// dnsNameToWire encodes a canonical name in uncompressed wire format
+func dnsNameToWire(name string) []byte {
+	var b []byte
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// dnsNameFromWire decodes an uncompressed wire format name as canonical name
+func dnsNameFromWire(b []byte) string {
+	var labels []string
+	for len(b) > 0 && b[0] != 0 && int(b[0]) < len(b) {
+		labels = append(labels, string(b[1:1+b[0]]))
+		b = b[1+b[0]:]
+	}
+	return canonicalDNSName(strings.Join(labels, "."))
+}
@@ -0,0 +1,227 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/things-go/go-socks5/statute"
+)
+
+// the Ed25519 example of RFC 8080 section 6
+func TestDNSSEC_RFC8080(t *testing.T) {
+	key, err := base64.StdEncoding.DecodeString("l02Woi0iS8Aa25FQkUd9RMzZHJpBoRQwAQEX1SxZJA4=")
+	require.NoError(t, err)
+	dnskey := append([]byte{0x01, 0x01, 3, dnssecAlgED25519}, key...)
+	assert.Equal(t, uint16(3613), dnskeyTag(dnskey))
+	ds := TrustAnchor{
+		KeyTag:     3613,
+		Algorithm:  dnssecAlgED25519,
+		DigestType: dnssecDigestSHA256,
+		Digest:     mustHex("3aa5ab37efce57f737fc1627013fee07bdf241bd10f3b1964ab55c78e79a304b"),
+	}
+	assert.True(t, ds.matches("example.com.", dnskey))
+
+	mx := dnsRR{
+		name:  "example.com.",
+		typ:   dnsmessage.TypeMX,
+		class: dnsmessage.ClassINET,
+		data:  append([]byte{0, 10}, dnsNameToWire("mail.example.com.")...),
+	}
+	signature, err := base64.StdEncoding.DecodeString("oL9krJun7xfBOIWcGHi7mag5/hdZrKWw15jPGrHpjQeRAvTdszaPD+QLs3fx8A4M3e23mRZ9VrbpMngwcrqNAg==")
+	require.NoError(t, err)
+	rdata := []byte{0, 15, dnssecAlgED25519, 2, 0, 0, 0x0e, 0x10, 0x55, 0xd4, 0xfc, 0x60, 0x55, 0xb9, 0x4c, 0xe0, 0x0e, 0x1d}
+	rdata = append(append(rdata, dnsNameToWire("example.com.")...), signature...)
+	sig, err := parseRRSIG(rdata)
+	require.NoError(t, err)
+	assert.Equal(t, "example.com.", sig.signer)
+	assert.NoError(t, sig.verify(dnskey, []dnsRR{mx}))
+	assert.NoError(t, sig.check("example.com.", time.Unix(1440000000, 0)))
+	assert.ErrorIs(t, sig.check("example.com.", time.Unix(1450000000, 0)), ErrDNSSECBogus)
+
+	mx.data[1] = 20
+	assert.Error(t, sig.verify(dnskey, []dnsRR{mx}))
+}
+
+// testZone is a signed zone of the authoritative stand-in
+type testZone struct {
+	name   string
+	key    ed25519.PrivateKey
+	dnskey dnsRR
+}
+
+func newTestZone(t *testing.T, name string) *testZone {
+	_, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	data := append([]byte{0x01, 0x01, 3, dnssecAlgED25519}, key.Public().(ed25519.PublicKey)...)
+	return &testZone{name, key, dnsRR{name, dnsTypeDNSKEY, dnsmessage.ClassINET, 3600, data}}
+}
+
+func (sf *testZone) ds() TrustAnchor {
+	h := sha256.New()
+	h.Write(dnsNameToWire(sf.name))
+	h.Write(sf.dnskey.data)
+	return TrustAnchor{sf.name, dnskeyTag(sf.dnskey.data), dnssecAlgED25519, dnssecDigestSHA256, h.Sum(nil)}
+}
+
+func (sf *testZone) dsRR(ttl uint32) dnsRR {
+	ds := sf.ds()
+	data := append([]byte{byte(ds.KeyTag >> 8), byte(ds.KeyTag), ds.Algorithm, ds.DigestType}, ds.Digest...)
+	return dnsRR{sf.name, dnsTypeDS, dnsmessage.ClassINET, ttl, data}
+}
+
+// sign returns the RRSIG of the RRset, valid from inception to expiration
+func (sf *testZone) sign(rrs []dnsRR, inception, expiration time.Time) dnsRR {
+	rr := rrs[0]
+	rdata := []byte{byte(rr.typ >> 8), byte(rr.typ), dnssecAlgED25519, byte(dnsLabelCount(rr.name))}
+	for _, v := range []uint32{rr.ttl, uint32(expiration.Unix()), uint32(inception.Unix())} {
+		rdata = append(rdata, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+	tag := dnskeyTag(sf.dnskey.data)
+	rdata = append(append(rdata, byte(tag>>8), byte(tag)), dnsNameToWire(sf.name)...)
+	sig, _ := parseRRSIG(rdata)
+	rdata = append(rdata, ed25519.Sign(sf.key, sig.signedData(rrs))...)
+	return dnsRR{rr.name, dnsTypeRRSIG, dnsmessage.ClassINET, rr.ttl, rdata}
+}
+
+// serveTestZones serves the records over udp like a recursive resolver would
+func serveTestZones(t *testing.T, records []dnsRR) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var p dnsmessage.Parser
+			h, err := p.Start(buf[:n])
+			if err != nil {
+				continue
+			}
+			q, err := p.Question()
+			if err != nil {
+				continue
+			}
+			qname := canonicalDNSName(q.Name.String())
+
+			b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: h.ID, Response: true, RecursionAvailable: true})
+			b.StartQuestions() //nolint: errcheck
+			b.Question(q)      //nolint: errcheck
+			b.StartAnswers()   //nolint: errcheck
+			for name := qname; ; {
+				var cname string
+				for _, rr := range records {
+					if rr.name != name {
+						continue
+					}
+					rrsigType := dnsmessage.Type(0)
+					if rr.typ == dnsTypeRRSIG {
+						rrsigType = dnsmessage.Type(uint16(rr.data[0])<<8 | uint16(rr.data[1]))
+					}
+					if rr.typ != q.Type && rr.typ != dnsmessage.TypeCNAME && rrsigType != q.Type && rrsigType != dnsmessage.TypeCNAME {
+						continue
+					}
+					rh := dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(rr.name), Type: rr.typ, Class: rr.class, TTL: rr.ttl}
+					switch rr.typ {
+					case dnsmessage.TypeA:
+						var a [4]byte
+						copy(a[:], rr.data)
+						b.AResource(rh, dnsmessage.AResource{A: a}) //nolint: errcheck
+					case dnsmessage.TypeCNAME:
+						cname = dnsNameFromWire(rr.data)
+						b.CNAMEResource(rh, dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName(cname)}) //nolint: errcheck
+					default:
+						b.UnknownResource(rh, dnsmessage.UnknownResource{Type: rr.typ, Data: rr.data}) //nolint: errcheck
+					}
+				}
+				if cname == "" || q.Type == dnsmessage.TypeCNAME {
+					break
+				}
+				name = cname
+			}
+			msg, err := b.Finish()
+			if err != nil {
+				continue
+			}
+			conn.WriteTo(msg, addr) //nolint: errcheck
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestDNSSECResolver(t *testing.T) {
+	now := time.Now()
+	from, until := now.Add(-time.Hour), now.Add(time.Hour)
+
+	root := newTestZone(t, ".")
+	zone := newTestZone(t, "test.")
+	rogue := newTestZone(t, "test.")
+
+	a := func(name string, ip net.IP) dnsRR {
+		return dnsRR{name, dnsmessage.TypeA, dnsmessage.ClassINET, 300, ip.To4()}
+	}
+	www := a("www.test.", net.IPv4(192, 0, 2, 1))
+	cname := dnsRR{"alias.test.", dnsmessage.TypeCNAME, dnsmessage.ClassINET, 300, dnsNameToWire("www.test.")}
+	unsigned := a("unsigned.test.", net.IPv4(192, 0, 2, 3))
+	forged := a("forged.test.", net.IPv4(192, 0, 2, 4))
+	expired := a("expired.test.", net.IPv4(192, 0, 2, 5))
+	ds := zone.dsRR(3600)
+
+	server := serveTestZones(t, []dnsRR{
+		root.dnskey, root.sign([]dnsRR{root.dnskey}, from, until),
+		ds, root.sign([]dnsRR{ds}, from, until),
+		zone.dnskey, zone.sign([]dnsRR{zone.dnskey}, from, until),
+		www, zone.sign([]dnsRR{www}, from, until),
+		cname, zone.sign([]dnsRR{cname}, from, until),
+		unsigned,
+		forged, rogue.sign([]dnsRR{forged}, from, until),
+		expired, zone.sign([]dnsRR{expired}, now.Add(-2*time.Hour), from),
+	})
+
+	r := NewDNSSECResolver(server, root.ds())
+	for _, name := range []string{"www.test", "WWW.test.", "alias.test"} {
+		_, ip, err := r.Resolve(context.Background(), name)
+		require.NoError(t, err, name)
+		assert.True(t, ip.Equal(net.IPv4(192, 0, 2, 1)), name)
+	}
+	for _, name := range []string{"unsigned.test", "forged.test", "expired.test"} {
+		_, _, err := r.Resolve(context.Background(), name)
+		assert.ErrorIs(t, err, ErrDNSSECBogus, name)
+	}
+	_, _, err := r.Resolve(context.Background(), "missing.test")
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrDNSSECBogus)
+
+	t.Run("untrusted anchor", func(t *testing.T) {
+		r := NewDNSSECResolver(server, rogue.ds())
+		_, _, err := r.Resolve(context.Background(), "www.test")
+		assert.ErrorIs(t, err, ErrDNSSECBogus)
+	})
+
+	t.Run("connect", func(t *testing.T) {
+		srv := NewServer(WithResolver(NewDNSSECResolver(server, root.ds())))
+		reqHead := statute.Request{
+			Version: statute.VersionSocks5,
+			Command: statute.CommandConnect,
+			DstAddr: statute.AddrSpec{FQDN: "forged.test", Port: 80, AddrType: statute.ATYPDomain},
+		}
+		rsp := new(MockConn)
+		req, err := ParseRequest(bytes.NewBuffer(reqHead.Bytes()))
+		require.NoError(t, err)
+		require.Error(t, srv.handleRequest(rsp, req))
+		assert.Equal(t, statute.RepHostUnreachable, rsp.buf.Bytes()[1])
+	})
+}
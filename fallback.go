@@ -0,0 +1,149 @@
+package socks5
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// FallbackHandler serves a connection which does not start with a SOCKS5
+// greeting, so the proxy can share its port with another service. The bytes
+// the server already read are replayed by conn. The handler owns conn and
+// must close it.
+type FallbackHandler func(conn net.Conn) error
+
+// bufferedConn replays the bytes buffered by the reader before reading conn
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// Read implement interface io.Reader
+func (sf *bufferedConn) Read(b []byte) (int, error) { return sf.r.Read(b) }
+
+// CloseWrite closes the write side of the connection, if supported
+func (sf *bufferedConn) CloseWrite() error {
+	if cw, ok := sf.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return ErrNotSupported
+}
+
+// HTTP fallback timeouts defaults
+const (
+	defaultHTTPReadHeaderTimeout = 30 * time.Second
+	defaultHTTPReadTimeout       = time.Minute
+	defaultHTTPIdleTimeout       = 2 * time.Minute
+)
+
+// HTTPFallback returns a FallbackHandler serving the connections with h as
+// plain HTTP, with the default timeouts of HTTPServerFallback.
+// To serve HTTPS, wrap the conn with tls.Server before.
+func HTTPFallback(h http.Handler) FallbackHandler {
+	return HTTPServerFallback(&http.Server{Handler: h}) //nolint: gosec
+}
+
+// HTTPServerFallback returns a FallbackHandler serving the connections as
+// plain HTTP with the handler, timeouts, header limit and error log of srv.
+// The zero timeouts default to 30 seconds to read the header, one minute
+// to read the request and two minutes of keep-alive idleness.
+func HTTPServerFallback(srv *http.Server) FallbackHandler {
+	readHeaderTimeout, readTimeout, idleTimeout := srv.ReadHeaderTimeout, srv.ReadTimeout, srv.IdleTimeout
+	if readHeaderTimeout <= 0 {
+		readHeaderTimeout = defaultHTTPReadHeaderTimeout
+	}
+	if readTimeout <= 0 {
+		readTimeout = defaultHTTPReadTimeout
+	}
+	if idleTimeout <= 0 {
+		idleTimeout = defaultHTTPIdleTimeout
+	}
+	return func(conn net.Conn) error {
+		l := &singleConnListener{conn: conn, addr: conn.LocalAddr(), done: make(chan struct{})}
+		s := &http.Server{
+			Handler:           srv.Handler,
+			ReadHeaderTimeout: readHeaderTimeout,
+			ReadTimeout:       readTimeout,
+			WriteTimeout:      srv.WriteTimeout,
+			IdleTimeout:       idleTimeout,
+			MaxHeaderBytes:    srv.MaxHeaderBytes,
+			ErrorLog:          srv.ErrorLog,
+			ConnState: func(_ net.Conn, state http.ConnState) {
+				if state == http.StateClosed || state == http.StateHijacked {
+					l.Close()
+				}
+			},
+		}
+		err := s.Serve(l)
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		return err
+	}
+}
+
+// ForwardFallback returns a FallbackHandler forwarding the connections to
+// the TCP address, such as a web server listening on another port.
+func ForwardFallback(network, addr string) FallbackHandler {
+	return func(conn net.Conn) error {
+		defer conn.Close()
+		target, err := net.Dial(network, addr)
+		if err != nil {
+			return err
+		}
+		defer target.Close()
+
+		errCh := make(chan error, 2)
+		forward := func(dst io.Writer, src io.Reader) {
+			_, err := io.Copy(dst, src)
+			if cw, ok := dst.(closeWriter); ok {
+				cw.CloseWrite() //nolint: errcheck
+			}
+			errCh <- err
+		}
+		go forward(target, conn)
+		go forward(conn, target)
+		for i := 0; i < 2; i++ {
+			if e := <-errCh; e != nil && err == nil {
+				err = e
+			}
+		}
+		return err
+	}
+}
+
+// singleConnListener is a net.Listener which accepts one connection,
+// then blocks until closed.
+type singleConnListener struct {
+	mu   sync.Mutex
+	conn net.Conn
+	addr net.Addr
+	once sync.Once
+	done chan struct{}
+}
+
+// Accept implement interface net.Listener
+func (sf *singleConnListener) Accept() (net.Conn, error) {
+	sf.mu.Lock()
+	conn := sf.conn
+	sf.conn = nil
+	sf.mu.Unlock()
+	if conn != nil {
+		return conn, nil
+	}
+	<-sf.done
+	return nil, net.ErrClosed
+}
+
+// Close implement interface net.Listener
+func (sf *singleConnListener) Close() error {
+	sf.once.Do(func() { close(sf.done) })
+	return nil
+}
+
+// Addr implement interface net.Listener
+func (sf *singleConnListener) Addr() net.Addr { return sf.addr }
@@ -0,0 +1,89 @@
+package socks5
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/things-go/go-socks5/statute"
+)
+
+func serveFallback(t *testing.T, h FallbackHandler) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go NewServer(WithFallback(h)).Serve(l) //nolint: errcheck
+	return l.Addr().String()
+}
+
+func TestFallback_HTTP(t *testing.T) {
+	addr := serveFallback(t, HTTPFallback(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello %s", r.URL.Path)
+	})))
+
+	for i := 0; i < 2; i++ {
+		rsp, err := http.Get("http://" + addr + "/site")
+		require.NoError(t, err)
+		body, err := io.ReadAll(rsp.Body)
+		rsp.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "hello /site", string(body))
+	}
+
+	// SOCKS5 clients are still served on the same port
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write(statute.NewMethodRequest(statute.VersionSocks5, []byte{statute.MethodNoAuth}).Bytes())
+	require.NoError(t, err)
+	b := make([]byte, 2)
+	_, err = io.ReadFull(conn, b)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{statute.VersionSocks5, statute.MethodNoAuth}, b)
+}
+
+func TestFallback_HTTPIdleTimeout(t *testing.T) {
+	addr := serveFallback(t, HTTPServerFallback(&http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "ok")
+		}),
+		IdleTimeout: 50 * time.Millisecond,
+	}))
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	require.NoError(t, err)
+
+	// the keep-alive connection is closed once idle
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second)) //nolint: errcheck
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "200 OK")
+}
+
+func TestFallback_Forward(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "backend")
+	}))
+	defer backend.Close()
+	addr := serveFallback(t, ForwardFallback("tcp", backend.Listener.Addr().String()))
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.0\r\nHost: example.com\r\n\r\n"))
+	require.NoError(t, err)
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "200 OK")
+	assert.Contains(t, string(out), "backend")
+}
@@ -0,0 +1,153 @@
+package socks5
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+
+	"github.com/things-go/go-socks5/statute"
+)
+
+// fqdn error defined
+var (
+	// ErrInvalidFQDN is returned for a domain name which can not be normalized
+	ErrInvalidFQDN = errors.New("invalid domain name")
+	// ErrIllegalFQDNChar is returned for a domain name with bytes which
+	// are never valid in a name, such as NUL or invalid UTF-8
+	ErrIllegalFQDNChar = fmt.Errorf("%w: illegal character", ErrInvalidFQDN)
+)
+
+// idnaProfile is the lookup profile, without the STD3 rules so names
+// with underscores pass; the characters are checked afterwards.
+var idnaProfile = idna.New(
+	idna.MapForLookup(),
+	idna.BidiRule(),
+	idna.Transitional(false),
+	idna.StrictDomainName(false),
+)
+
+// NormalizeFQDN returns the canonical form of a requested domain name:
+// converted to ASCII (IDNA), lowercased and without the trailing dot.
+// Names which are IP literals, including the numeric forms accepted by
+// inet_aton such as "127.1" or "0x7f000001", are returned as ip instead.
+func NormalizeFQDN(name string) (fqdn string, ip net.IP, err error) {
+	if !utf8.ValidString(name) {
+		return "", nil, ErrIllegalFQDNChar
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f || r == ' ' {
+			return "", nil, ErrIllegalFQDNChar
+		}
+	}
+
+	if strings.HasPrefix(name, "[") && strings.HasSuffix(name, "]") {
+		if ip = net.ParseIP(name[1 : len(name)-1]); ip != nil {
+			return "", ip, nil
+		}
+	}
+	if ip = net.ParseIP(name); ip != nil {
+		return "", ip, nil
+	}
+
+	name = strings.TrimSuffix(name, ".")
+	if ip = parseInetAton(name); ip != nil {
+		return "", ip, nil
+	}
+
+	fqdn, err = idnaProfile.ToASCII(name)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrInvalidFQDN, err)
+	}
+	fqdn = strings.ToLower(fqdn)
+	if fqdn == "" || len(fqdn) > 253 {
+		return "", nil, fmt.Errorf("%w: bad length", ErrInvalidFQDN)
+	}
+	labels := strings.Split(fqdn, ".")
+	for _, label := range labels {
+		if label == "" || len(label) > 63 {
+			return "", nil, fmt.Errorf("%w: bad label length", ErrInvalidFQDN)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return "", nil, fmt.Errorf("%w: label starts or ends with hyphen", ErrInvalidFQDN)
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return "", nil, ErrIllegalFQDNChar
+			}
+		}
+	}
+	// a numeric top level label is not a name, but neither a valid address
+	if strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return "", nil, fmt.Errorf("%w: numeric top level label", ErrInvalidFQDN)
+	}
+	return fqdn, nil, nil
+}
+
+// normalizeAddrSpec normalizes the FQDN of the address in place,
+// an IP literal becomes the address' IP.
+func normalizeAddrSpec(as *statute.AddrSpec) error {
+	if as.FQDN == "" {
+		return nil
+	}
+	fqdn, ip, err := NormalizeFQDN(as.FQDN)
+	if err != nil {
+		return err
+	}
+	if ip == nil {
+		as.FQDN = fqdn
+		return nil
+	}
+	as.FQDN, as.IP, as.AddrType = "", ip, statute.ATYPIPv6
+	if ip4 := ip.To4(); ip4 != nil {
+		as.IP, as.AddrType = ip4, statute.ATYPIPv4
+	}
+	return nil
+}
+
+// parseInetAton parses the IPv4 forms accepted by inet_aton: one to four
+// parts in decimal, octal (leading 0) or hex (leading 0x), the last part
+// filling the remaining bytes.
+func parseInetAton(s string) net.IP {
+	parts := strings.Split(s, ".")
+	if len(parts) > 4 {
+		return nil
+	}
+	var values []uint64
+	for _, p := range parts {
+		base := 10
+		switch {
+		case strings.HasPrefix(p, "0x") || strings.HasPrefix(p, "0X"):
+			base, p = 16, p[2:]
+		case len(p) > 1 && p[0] == '0':
+			base, p = 8, p[1:]
+		}
+		if p == "" || strings.ContainsAny(p, "+-_") {
+			return nil
+		}
+		v, err := strconv.ParseUint(p, base, 32)
+		if err != nil {
+			return nil
+		}
+		values = append(values, v)
+	}
+
+	var addr uint64
+	last := len(values) - 1
+	for i, v := range values[:last] {
+		if v > 0xff {
+			return nil
+		}
+		addr |= v << (8 * uint(3-i))
+	}
+	if values[last] >= 1<<(8*uint(4-last)) {
+		return nil
+	}
+	addr |= values[last]
+	return net.IPv4(byte(addr>>24), byte(addr>>16), byte(addr>>8), byte(addr))
+}
@@ -0,0 +1,105 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/things-go/go-socks5/statute"
+)
+
+func TestNormalizeFQDN(t *testing.T) {
+	tests := []struct {
+		name string
+		fqdn string
+		ip   net.IP
+		err  error
+	}{
+		{"Example.COM.", "example.com", nil, nil},
+		{"bücher.example", "xn--bcher-kva.example", nil, nil},
+		{"XN--BCHER-KVA.example", "xn--bcher-kva.example", nil, nil},
+		{"_srv.example.com", "_srv.example.com", nil, nil},
+		{"127.0.0.1", "", net.IPv4(127, 0, 0, 1), nil},
+		{"127.1", "", net.IPv4(127, 0, 0, 1), nil},
+		{"0x7f000001", "", net.IPv4(127, 0, 0, 1), nil},
+		{"2130706433.", "", net.IPv4(127, 0, 0, 1), nil},
+		{"0177.0.0.01", "", net.IPv4(127, 0, 0, 1), nil},
+		{"[::1]", "", net.IPv6loopback, nil},
+		{"::1", "", net.IPv6loopback, nil},
+		{"example.com\x00.evil", "", nil, ErrIllegalFQDNChar},
+		{"exa mple.com", "", nil, ErrIllegalFQDNChar},
+		{"\xff.com", "", nil, ErrIllegalFQDNChar},
+		{"a/b.com", "", nil, ErrIllegalFQDNChar},
+		{"", "", nil, ErrInvalidFQDN},
+		{"example..com", "", nil, ErrInvalidFQDN},
+		{"-example.com", "", nil, ErrInvalidFQDN},
+		{"1.2.3.4.5", "", nil, ErrInvalidFQDN},
+		{"256.1.1.1", "", nil, ErrInvalidFQDN},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fqdn, ip, err := NormalizeFQDN(tt.name)
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.fqdn, fqdn)
+			assert.True(t, tt.ip.Equal(ip), "got ip %v", ip)
+		})
+	}
+}
+
+func TestRequest_Connect_InvalidFQDN(t *testing.T) {
+	srv := NewServer()
+
+	for _, c := range []struct {
+		fqdn string
+		rep  uint8
+	}{
+		{"example.com\x00", statute.RepAddrTypeNotSupported},
+		{"example..com", statute.RepHostUnreachable},
+	} {
+		reqHead := statute.Request{
+			Version: statute.VersionSocks5,
+			Command: statute.CommandConnect,
+			DstAddr: statute.AddrSpec{FQDN: c.fqdn, Port: 80, AddrType: statute.ATYPDomain},
+		}
+		rsp := new(MockConn)
+		req, err := ParseRequest(bytes.NewBuffer(reqHead.Bytes()))
+		require.NoError(t, err)
+		require.Error(t, srv.handleRequest(rsp, req))
+		assert.Equal(t, c.rep, rsp.buf.Bytes()[1])
+	}
+}
+
+func TestRequest_NormalizedBeforeRules(t *testing.T) {
+	var seen statute.AddrSpec
+	srv := NewServer(WithRule(ruleFunc(func(req *Request) bool {
+		seen = *req.DestAddr
+		return false
+	})))
+
+	reqHead := statute.Request{
+		Version: statute.VersionSocks5,
+		Command: statute.CommandConnect,
+		DstAddr: statute.AddrSpec{FQDN: "0x7f.1", Port: 80, AddrType: statute.ATYPDomain},
+	}
+	rsp := new(MockConn)
+	req, err := ParseRequest(bytes.NewBuffer(reqHead.Bytes()))
+	require.NoError(t, err)
+	require.Error(t, srv.handleRequest(rsp, req))
+	assert.Equal(t, "", seen.FQDN)
+	assert.Equal(t, statute.ATYPIPv4, seen.AddrType)
+	assert.True(t, seen.IP.Equal(net.IPv4(127, 0, 0, 1)))
+}
+
+type ruleFunc func(req *Request) bool
+
+func (f ruleFunc) Allow(ctx context.Context, req *Request) (context.Context, bool) {
+	return ctx, f(req)
+}
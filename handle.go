@@ -7,7 +7,7 @@ import (
 	"io"
 	"net"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/things-go/go-socks5/statute"
 )
@@ -32,6 +32,8 @@ type Request struct {
 	Reader io.Reader
 	// RawDestAddr of the desired destination
 	RawDestAddr *statute.AddrSpec
+	// Resolver is the name of the resolver selected for the request
+	Resolver string
 }
 
 // ParseRequest creates a new Request from the tcp connection
@@ -51,11 +53,43 @@ func ParseRequest(bufConn io.Reader) (*Request, error) {
 func (sf *Server) handleRequest(write io.Writer, req *Request) error {
 	var err error
 
+	// account everything exchanged with the client from here on,
+	// the legacy handlers are given the original writer
+	rsp, isRsp := write.(*response)
+	if !isRsp {
+		rsp = newResponse(write)
+	}
+	orig := rsp.w
+	write = rsp
+	rsp.setCommand(req.Command)
+	req.Reader = countReader{req.Reader, rsp}
+	if sf.sessionRecorder != nil {
+		start := time.Now()
+		defer func() { sf.sessionRecorder.RecordSession(newSessionRecord(req, rsp, start)) }()
+	}
+
 	ctx := context.Background()
-	// Resolve the address if we have a FQDN
+	// Normalize the FQDN so resolution and rules see its canonical form
 	dest := req.RawDestAddr
+	if sf.normalizeFQDN {
+		fqdn := dest.FQDN
+		if err := normalizeAddrSpec(dest); err != nil {
+			rep := statute.RepHostUnreachable
+			if errors.Is(err, ErrIllegalFQDNChar) {
+				rep = statute.RepAddrTypeNotSupported
+			}
+			if err := SendReply(write, rep, nil); err != nil {
+				return fmt.Errorf("failed to send reply, %v", err)
+			}
+			return fmt.Errorf("invalid destination[%q], %v", fqdn, err)
+		}
+	}
+
+	// Resolve the address if we have a FQDN
+	var resolver NameResolver
+	req.Resolver, resolver = sf.selectResolver(ctx, req)
 	if dest.FQDN != "" {
-		ctx, dest.IP, err = sf.resolver.Resolve(ctx, dest.FQDN)
+		ctx, dest.IP, err = resolver.Resolve(ctx, dest.FQDN)
 		if err != nil {
 			if err := SendReply(write, statute.RepHostUnreachable, nil); err != nil {
 				return fmt.Errorf("failed to send reply, %v", err)
@@ -80,21 +114,42 @@ func (sf *Server) handleRequest(write io.Writer, req *Request) error {
 		return fmt.Errorf("bind to %v blocked by rules", req.RawDestAddr)
 	}
 
+	// Enforce the destination limits
+	if sf.destLimiter != nil && req.Command == statute.CommandConnect {
+		release, ok := sf.destLimiter.acquire(ctx, req)
+		if !ok {
+			if err := SendReply(write, statute.RepRuleFailure, nil); err != nil {
+				return fmt.Errorf("failed to send reply, %v", err)
+			}
+			return fmt.Errorf("connect to %v exceeds destination limits", req.DestAddr)
+		}
+		defer release()
+	}
+
 	// Switch on the command
 	switch req.Command {
 	case statute.CommandConnect:
+		if sf.connectHandler != nil {
+			return sf.connectHandler(ctx, rsp, req)
+		}
 		if sf.userConnectHandle != nil {
-			return sf.userConnectHandle(ctx, write, req)
+			return sf.userConnectHandle(ctx, orig, req)
 		}
 		return sf.handleConnect(ctx, write, req)
 	case statute.CommandBind:
+		if sf.bindHandler != nil {
+			return sf.bindHandler(ctx, rsp, req)
+		}
 		if sf.userBindHandle != nil {
-			return sf.userBindHandle(ctx, write, req)
+			return sf.userBindHandle(ctx, orig, req)
 		}
 		return sf.handleBind(ctx, write, req)
 	case statute.CommandAssociate:
+		if sf.associateHandler != nil {
+			return sf.associateHandler(ctx, rsp, req)
+		}
 		if sf.userAssociateHandle != nil {
-			return sf.userAssociateHandle(ctx, write, req)
+			return sf.userAssociateHandle(ctx, orig, req)
 		}
 		return sf.handleAssociate(ctx, write, req)
 	default:
@@ -105,22 +160,38 @@ func (sf *Server) handleRequest(write io.Writer, req *Request) error {
 	}
 }
 
+// selectResolver returns the name and the resolver to use for the request
+func (sf *Server) selectResolver(ctx context.Context, req *Request) (string, NameResolver) {
+	if sf.resolverSelector != nil {
+		if name, resolver, ok := sf.resolverSelector.Select(ctx, req); ok && resolver != nil {
+			return name, resolver
+		}
+	}
+	return DefaultResolverName, sf.resolver
+}
+
 // handleConnect is used to handle a connect command
 func (sf *Server) handleConnect(ctx context.Context, writer io.Writer, request *Request) error {
 	// Attempt to connect
 	var target net.Conn
 	var err error
 
-	if sf.dialWithRequest != nil {
-		target, err = sf.dialWithRequest(ctx, "tcp", request.DestAddr.String(), request)
-	} else {
-		dial := sf.dial
-		if dial == nil {
-			dial = func(ctx context.Context, net_, addr string) (net.Conn, error) {
-				return net.Dial(net_, addr)
+	// the pool does not know the per request dialer, which takes precedence
+	if sf.preconnect != nil && sf.dialWithRequest == nil {
+		target = sf.preconnect.Get(request.DestAddr.String())
+	}
+	if target == nil {
+		if sf.dialWithRequest != nil {
+			target, err = sf.dialWithRequest(ctx, "tcp", request.DestAddr.String(), request)
+		} else {
+			dial := sf.dial
+			if dial == nil {
+				dial = func(ctx context.Context, net_, addr string) (net.Conn, error) {
+					return net.Dial(net_, addr)
+				}
 			}
+			target, err = dial(ctx, "tcp", request.DestAddr.String())
 		}
-		target, err = dial(ctx, "tcp", request.DestAddr.String())
 	}
 	if err != nil {
 		msg := err.Error()
@@ -137,6 +208,18 @@ func (sf *Server) handleConnect(ctx context.Context, writer io.Writer, request *
 	}
 	defer target.Close()
 
+	// Originate TLS if the rules asked for it
+	if IsOriginateTLS(ctx) {
+		tlsConn, err := sf.tlsOrigination.client(ctx, target, request)
+		if err != nil {
+			if err := SendReply(writer, statute.RepHostUnreachable, nil); err != nil {
+				return fmt.Errorf("failed to send reply, %v", err)
+			}
+			return fmt.Errorf("tls handshake with %v failed, %v", request.RawDestAddr, err)
+		}
+		target = tlsConn
+	}
+
 	// Send success
 	if err := SendReply(writer, statute.RepSuccess, target.LocalAddr()); err != nil {
 		return fmt.Errorf("failed to send reply, %v", err)
@@ -168,13 +251,10 @@ func (sf *Server) handleBind(_ context.Context, writer io.Writer, _ *Request) er
 
 // handleAssociate is used to handle a connect command
 func (sf *Server) handleAssociate(ctx context.Context, writer io.Writer, request *Request) error {
-	// Attempt to connect
-	dial := sf.dial
-	if dial == nil {
-		dial = func(_ context.Context, net_, addr string) (net.Conn, error) {
-			return net.Dial(net_, addr)
-		}
+	if sf.udpRelay != nil {
+		return sf.handleSharedAssociate(ctx, writer, request)
 	}
+
 	bindLn, err := net.ListenUDP("udp", nil)
 	if err != nil {
 		if err := SendReply(writer, statute.RepServerFailure, nil); err != nil {
@@ -189,21 +269,14 @@ func (sf *Server) handleAssociate(ctx context.Context, writer io.Writer, request
 		return fmt.Errorf("failed to send reply, %v", err)
 	}
 
+	assoc := sf.newUDPAssociation(ctx, bindLn, request)
 	sf.goFunc(func() {
 		// read from client and write to remote server
-		conns := sync.Map{}
 		bufPool := sf.bufferPool.Get()
 		defer func() {
 			sf.bufferPool.Put(bufPool)
 			bindLn.Close()
-			conns.Range(func(key, value any) bool {
-				if connTarget, ok := value.(net.Conn); !ok {
-					sf.logger.Errorf("conns has illegal item %v:%v", key, value)
-				} else {
-					connTarget.Close()
-				}
-				return true
-			})
+			assoc.Close()
 		}()
 		for {
 			n, srcAddr, err := bindLn.ReadFromUDP(bufPool[:cap(bufPool)])
@@ -224,69 +297,25 @@ func (sf *Server) handleAssociate(ctx context.Context, writer io.Writer, request
 				continue
 			}
 
-			connKey := srcAddr.String() + "--" + pk.DstAddr.String()
-
-			if target, ok := conns.Load(connKey); !ok {
-				// if the 'connection' doesn't exist, create one and store it
-				targetNew, err := dial(ctx, "udp", pk.DstAddr.String())
-				if err != nil {
-					sf.logger.Errorf("connect to %v failed, %v", pk.DstAddr, err)
-					// TODO:continue or return Error?
-					continue
-				}
-				conns.Store(connKey, targetNew)
-				// read from remote server and write to original client
-				sf.goFunc(func() {
-					bufPool := sf.bufferPool.Get()
-					defer func() {
-						targetNew.Close()
-						conns.Delete(connKey)
-						sf.bufferPool.Put(bufPool)
-					}()
-
-					for {
-						buf := bufPool[:cap(bufPool)]
-						n, err := targetNew.Read(buf)
-						if err != nil {
-							if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
-								return
-							}
-							sf.logger.Errorf("read data from remote %s failed, %v", targetNew.RemoteAddr().String(), err)
-							return
-						}
-						tmpBufPool := sf.bufferPool.Get()
-						proBuf := tmpBufPool
-						proBuf = append(proBuf, pk.Header()...)
-						proBuf = append(proBuf, buf[:n]...)
-						if _, err := bindLn.WriteTo(proBuf, srcAddr); err != nil {
-							sf.bufferPool.Put(tmpBufPool)
-							sf.logger.Errorf("write data to client %s failed, %v", srcAddr, err)
-							return
-						}
-						sf.bufferPool.Put(tmpBufPool)
-					}
-				})
-				if _, err := targetNew.Write(pk.Data); err != nil {
-					sf.logger.Errorf("write data to remote server %s failed, %v", targetNew.RemoteAddr().String(), err)
-					return
-				}
-			} else {
-				if _, err := target.(net.Conn).Write(pk.Data); err != nil {
-					sf.logger.Errorf("write data to remote server %s failed, %v", target.(net.Conn).RemoteAddr().String(), err)
-					return
-				}
+			if err := assoc.forward(srcAddr, pk); err != nil {
+				return
 			}
 		}
 	})
 
+	err = sf.waitAssociateDone(request.Reader)
+	bindLn.Close()
+	return err
+}
+
+// waitAssociateDone reads the control connection of a udp associate until it is closed
+func (sf *Server) waitAssociateDone(r io.Reader) error {
 	buf := sf.bufferPool.Get()
 	defer sf.bufferPool.Put(buf)
 
 	for {
-		_, err := request.Reader.Read(buf[:cap(buf)])
-		// sf.logger.Errorf("read data from client %s, %d bytesm, err is %+v", request.RemoteAddr.String(), num, err)
+		_, err := r.Read(buf[:cap(buf)])
 		if err != nil {
-			bindLn.Close()
 			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
 				return nil
 			}
@@ -326,6 +355,9 @@ func SendReply(w io.Writer, rep uint8, bindAddr net.Addr) error {
 		}
 	}
 	// Send the message
+	if rw, ok := w.(ResponseWriter); ok {
+		return rw.Reply(rsp.Response, rsp.BndAddr)
+	}
 	_, err := w.Write(rsp.Bytes())
 	return err
 }
@@ -0,0 +1,165 @@
+package socks5
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IntrospectionCredentials is a CredentialStore which treats the password
+// as an OAuth2 access token and validates it against a token introspection
+// endpoint (RFC 7662). Active results are cached until the token expires.
+type IntrospectionCredentials struct {
+	// Endpoint is the introspection endpoint url
+	Endpoint string
+	// ClientID and ClientSecret authenticate the proxy to the endpoint
+	ClientID     string
+	ClientSecret string
+	// Scopes that must all be granted to the token, optional
+	Scopes []string
+	// Client is used to call the endpoint.
+	// Defaults to an http.Client with a 10 second timeout.
+	Client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]introspectionEntry
+}
+
+type introspectionEntry struct {
+	identity map[string]string
+	expires  time.Time
+}
+
+// introspectionResponse is the subset of the RFC 7662 response we use
+type introspectionResponse struct {
+	Active   bool   `json:"active"`
+	Scope    string `json:"scope"`
+	Subject  string `json:"sub"`
+	ClientID string `json:"client_id"`
+	Username string `json:"username"`
+	Exp      int64  `json:"exp"`
+}
+
+// NewIntrospectionCredentials new introspection credential store
+func NewIntrospectionCredentials(endpoint, clientID, clientSecret string, scopes ...string) *IntrospectionCredentials {
+	return &IntrospectionCredentials{
+		Endpoint:     endpoint,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       scopes,
+	}
+}
+
+// Valid implement interface CredentialStore
+func (sf *IntrospectionCredentials) Valid(user, password, userAddr string) bool {
+	_, ok := sf.Identity(user, password, userAddr)
+	return ok
+}
+
+// Identity implement interface IdentityCredentialStore.
+// The identity holds the token's "subject" and space separated "scope".
+func (sf *IntrospectionCredentials) Identity(_, password, _ string) (map[string]string, bool) {
+	if password == "" {
+		return nil, false
+	}
+	sum := sha256.Sum256([]byte(password))
+	key := hex.EncodeToString(sum[:])
+
+	now := time.Now()
+	sf.mu.Lock()
+	entry, ok := sf.cache[key]
+	sf.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.identity, true
+	}
+
+	rsp, err := sf.introspect(password)
+	if err != nil || !rsp.Active || !sf.hasScopes(rsp.Scope) {
+		return nil, false
+	}
+	identity := map[string]string{
+		"subject": rsp.Subject,
+		"scope":   rsp.Scope,
+	}
+	if rsp.ClientID != "" {
+		identity["client_id"] = rsp.ClientID
+	}
+	if rsp.Exp == 0 {
+		// no expiry known, nothing to cache against
+		return identity, true
+	}
+	expires := time.Unix(rsp.Exp, 0)
+	if !now.Before(expires) {
+		return nil, false
+	}
+
+	sf.mu.Lock()
+	if sf.cache == nil {
+		sf.cache = make(map[string]introspectionEntry)
+	}
+	for k, v := range sf.cache {
+		if !now.Before(v.expires) {
+			delete(sf.cache, k)
+		}
+	}
+	sf.cache[key] = introspectionEntry{identity, expires}
+	sf.mu.Unlock()
+	return identity, true
+}
+
+func (sf *IntrospectionCredentials) introspect(token string) (*introspectionResponse, error) {
+	form := url.Values{
+		"token":           {token},
+		"token_type_hint": {"access_token"},
+	}
+	req, err := http.NewRequestWithContext(context.Background(),
+		http.MethodPost, sf.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(sf.ClientID), url.QueryEscape(sf.ClientSecret))
+
+	client := sf.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("introspection endpoint returned %s", resp.Status)
+	}
+	rsp := &introspectionResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(rsp); err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
+
+func (sf *IntrospectionCredentials) hasScopes(scope string) bool {
+	granted := strings.Fields(scope)
+	for _, want := range sf.Scopes {
+		found := false
+		for _, s := range granted {
+			if s == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
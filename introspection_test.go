@@ -0,0 +1,95 @@
+package socks5
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/things-go/go-socks5/statute"
+)
+
+func newIntrospectionServer(t *testing.T, calls *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "proxy" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		require.NoError(t, r.ParseForm())
+		rsp := map[string]interface{}{"active": false}
+		switch r.PostForm.Get("token") {
+		case "good":
+			rsp = map[string]interface{}{
+				"active": true,
+				"sub":    "alice",
+				"scope":  "openid proxy",
+				"exp":    time.Now().Add(time.Hour).Unix(),
+			}
+		case "narrow":
+			rsp = map[string]interface{}{
+				"active": true,
+				"sub":    "bob",
+				"scope":  "openid",
+				"exp":    time.Now().Add(time.Hour).Unix(),
+			}
+		case "expired":
+			rsp = map[string]interface{}{
+				"active": true,
+				"sub":    "carol",
+				"scope":  "proxy",
+				"exp":    time.Now().Add(-time.Minute).Unix(),
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rsp) //nolint: errcheck
+	}))
+}
+
+func TestIntrospectionCredentials(t *testing.T) {
+	var calls int32
+	ts := newIntrospectionServer(t, &calls)
+	defer ts.Close()
+
+	creds := NewIntrospectionCredentials(ts.URL, "proxy", "s3cret", "proxy")
+
+	identity, ok := creds.Identity("", "good", "")
+	require.True(t, ok)
+	assert.Equal(t, "alice", identity["subject"])
+	assert.Equal(t, "openid proxy", identity["scope"])
+
+	// served from cache
+	assert.True(t, creds.Valid("", "good", ""))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	assert.False(t, creds.Valid("", "narrow", ""))
+	assert.False(t, creds.Valid("", "expired", ""))
+	assert.False(t, creds.Valid("", "unknown", ""))
+	assert.False(t, creds.Valid("", "", ""))
+
+	bad := NewIntrospectionCredentials(ts.URL, "proxy", "wrong")
+	assert.False(t, bad.Valid("", "good", ""))
+}
+
+func TestIntrospectionCredentials_Authenticate(t *testing.T) {
+	var calls int32
+	ts := newIntrospectionServer(t, &calls)
+	defer ts.Close()
+
+	cator := UserPassAuthenticator{NewIntrospectionCredentials(ts.URL, "proxy", "s3cret")}
+	req := bytes.NewBuffer(statute.NewUserPassRequest(statute.UserPassAuthVersion, []byte("x"), []byte("good")).Bytes())
+	rsp := new(bytes.Buffer)
+
+	ctx, err := cator.Authenticate(req, rsp, "")
+	require.NoError(t, err)
+	assert.Equal(t, "x", ctx.Payload["username"])
+	assert.Equal(t, "alice", ctx.Payload["subject"])
+	assert.Equal(t, "openid proxy", ctx.Payload["scope"])
+}
@@ -0,0 +1,155 @@
+package socks5
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// DestinationLimits limits the connect requests to each destination
+// IP:port, after resolution and rewriting. Requests over the limits wait
+// up to Wait for a free slot, then are refused with RepRuleFailure.
+type DestinationLimits struct {
+	// MaxConcurrent sessions per destination, zero for no limit
+	MaxConcurrent int
+	// Rate of new connections per second per destination, zero for no limit
+	Rate float64
+	// Burst of new connections above the rate, defaults to the rate rounded up
+	Burst int
+	// PerUser applies the limits per authenticated user and destination
+	PerUser bool
+	// Wait is how long a request queues for a slot, zero refuses immediately
+	Wait time.Duration
+}
+
+// destinationLimiter enforces the DestinationLimits
+type destinationLimiter struct {
+	limits DestinationLimits
+	burst  float64
+
+	mu      sync.Mutex
+	states  map[string]*destinationState
+	sweepAt int
+}
+
+type destinationState struct {
+	active  int
+	waiters int
+	tokens  float64
+	last    time.Time
+	// changed is closed when a session ends
+	changed chan struct{}
+}
+
+func newDestinationLimiter(limits DestinationLimits) *destinationLimiter {
+	burst := float64(limits.Burst)
+	if burst <= 0 {
+		burst = math.Max(1, math.Ceil(limits.Rate))
+	}
+	return &destinationLimiter{
+		limits:  limits,
+		burst:   burst,
+		states:  make(map[string]*destinationState),
+		sweepAt: 1024,
+	}
+}
+
+// key returns the limiting key of the request
+func (sf *destinationLimiter) key(req *Request) string {
+	key := req.DestAddr.String()
+	if sf.limits.PerUser && req.AuthContext != nil {
+		key = req.AuthContext.Payload["username"] + "@" + key
+	}
+	return key
+}
+
+// acquire takes a slot for the request, waiting up to limits.Wait.
+// It returns the function to release the slot when the session ends.
+func (sf *destinationLimiter) acquire(ctx context.Context, req *Request) (func(), bool) {
+	key := sf.key(req)
+	deadline := time.Now().Add(sf.limits.Wait)
+
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	st, ok := sf.states[key]
+	if !ok {
+		sf.sweep()
+		st = &destinationState{tokens: sf.burst, last: time.Now(), changed: make(chan struct{})}
+		sf.states[key] = st
+	}
+	for {
+		now := time.Now()
+		sf.refill(st, now)
+		wait := deadline.Sub(now)
+		free := sf.limits.MaxConcurrent <= 0 || st.active < sf.limits.MaxConcurrent
+		if free && (sf.limits.Rate <= 0 || st.tokens >= 1) {
+			st.active++
+			if sf.limits.Rate > 0 {
+				st.tokens--
+			}
+			return func() { sf.release(st) }, true
+		}
+		if free {
+			// only waiting for a token
+			next := time.Duration((1 - st.tokens) / sf.limits.Rate * float64(time.Second))
+			if next > wait {
+				return nil, false
+			}
+			wait = next
+		}
+		if wait <= 0 {
+			return nil, false
+		}
+
+		changed := st.changed
+		st.waiters++
+		sf.mu.Unlock()
+		timer := time.NewTimer(wait)
+		select {
+		case <-changed:
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		timer.Stop()
+		sf.mu.Lock()
+		st.waiters--
+		if ctx.Err() != nil {
+			return nil, false
+		}
+	}
+}
+
+func (sf *destinationLimiter) release(st *destinationState) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	st.active--
+	close(st.changed)
+	st.changed = make(chan struct{})
+}
+
+// refill adds the tokens earned since the last refill, sf.mu must be held
+func (sf *destinationLimiter) refill(st *destinationState, now time.Time) {
+	if sf.limits.Rate > 0 {
+		st.tokens = math.Min(sf.burst, st.tokens+now.Sub(st.last).Seconds()*sf.limits.Rate)
+	}
+	st.last = now
+}
+
+// sweep drops the idle destinations once the map doubled, sf.mu must be held
+func (sf *destinationLimiter) sweep() {
+	if len(sf.states) < sf.sweepAt {
+		return
+	}
+	now := time.Now()
+	for key, st := range sf.states {
+		sf.refill(st, now)
+		if st.active == 0 && st.waiters == 0 && st.tokens >= sf.burst {
+			delete(sf.states, key)
+		}
+	}
+	sf.sweepAt = 2 * len(sf.states)
+	if sf.sweepAt < 1024 {
+		sf.sweepAt = 1024
+	}
+}
@@ -0,0 +1,101 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/things-go/go-socks5/statute"
+)
+
+func limitRequest(user string, port int) *Request {
+	return &Request{
+		AuthContext: &AuthContext{statute.MethodUserPassAuth, map[string]string{"username": user}},
+		DestAddr:    &statute.AddrSpec{IP: net.IPv4(192, 0, 2, 1), Port: port, AddrType: statute.ATYPIPv4},
+	}
+}
+
+func TestDestinationLimiter_Concurrent(t *testing.T) {
+	l := newDestinationLimiter(DestinationLimits{MaxConcurrent: 1, PerUser: true})
+	ctx := context.Background()
+
+	release, ok := l.acquire(ctx, limitRequest("foo", 80))
+	require.True(t, ok)
+	_, ok = l.acquire(ctx, limitRequest("foo", 80))
+	assert.False(t, ok)
+
+	// other destinations and other users have their own limits
+	r1, ok := l.acquire(ctx, limitRequest("foo", 443))
+	require.True(t, ok)
+	r2, ok := l.acquire(ctx, limitRequest("bar", 80))
+	require.True(t, ok)
+	r1()
+	r2()
+
+	release()
+	release, ok = l.acquire(ctx, limitRequest("foo", 80))
+	require.True(t, ok)
+
+	t.Run("queue", func(t *testing.T) {
+		l.limits.Wait = time.Second
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			release()
+		}()
+		start := time.Now()
+		r, ok := l.acquire(ctx, limitRequest("foo", 80))
+		require.True(t, ok)
+		assert.Less(t, time.Since(start), time.Second)
+		r()
+	})
+}
+
+func TestDestinationLimiter_Rate(t *testing.T) {
+	l := newDestinationLimiter(DestinationLimits{Rate: 20, Burst: 2})
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		release, ok := l.acquire(ctx, limitRequest("", 80))
+		require.True(t, ok)
+		release()
+	}
+	_, ok := l.acquire(ctx, limitRequest("", 80))
+	assert.False(t, ok)
+
+	l.limits.Wait = time.Second
+	start := time.Now()
+	release, ok := l.acquire(ctx, limitRequest("", 80))
+	require.True(t, ok)
+	release()
+	assert.GreaterOrEqual(t, time.Since(start), 25*time.Millisecond)
+}
+
+func TestRequest_Connect_DestinationLimits(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	lAddr := l.Addr().(*net.TCPAddr)
+
+	srv := NewServer(WithDestinationLimits(DestinationLimits{MaxConcurrent: 1}))
+	release, ok := srv.destLimiter.acquire(context.Background(), &Request{
+		DestAddr: &statute.AddrSpec{IP: lAddr.IP, Port: lAddr.Port, AddrType: statute.ATYPIPv4},
+	})
+	require.True(t, ok)
+	defer release()
+
+	reqHead := statute.Request{
+		Version: statute.VersionSocks5,
+		Command: statute.CommandConnect,
+		DstAddr: statute.AddrSpec{IP: lAddr.IP, Port: lAddr.Port, AddrType: statute.ATYPIPv4},
+	}
+	rsp := new(MockConn)
+	req, err := ParseRequest(bytes.NewBuffer(reqHead.Bytes()))
+	require.NoError(t, err)
+	require.Error(t, srv.handleRequest(rsp, req))
+	assert.Equal(t, statute.RepRuleFailure, rsp.buf.Bytes()[1])
+}
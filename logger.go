@@ -9,6 +9,21 @@ type Logger interface {
 	Errorf(format string, arg ...interface{})
 }
 
+// DebugLogger is a Logger which also takes debug messages,
+// such as the decoded protocol messages of each connection.
+type DebugLogger interface {
+	Logger
+	Debugf(format string, arg ...interface{})
+}
+
+// DebuggingLogger is a DebugLogger which tells whether it logs the debug
+// messages, such as a logger wrapping another one. The server does not build
+// the debug messages when Debugging returns false.
+type DebuggingLogger interface {
+	DebugLogger
+	Debugging() bool
+}
+
 // Std std logger
 type Std struct {
 	*log.Logger
@@ -23,3 +38,18 @@ func NewLogger(l *log.Logger) *Std {
 func (sf Std) Errorf(format string, args ...interface{}) {
 	sf.Logger.Printf("[E]: "+format, args...)
 }
+
+// Debug std logger which also logs debug messages
+type Debug struct {
+	Std
+}
+
+// NewDebugLogger new std debug logger with log.logger
+func NewDebugLogger(l *log.Logger) *Debug {
+	return &Debug{Std{l}}
+}
+
+// Debugf implement interface DebugLogger
+func (sf Debug) Debugf(format string, args ...interface{}) {
+	sf.Logger.Printf("[D]: "+format, args...)
+}
@@ -0,0 +1,387 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/things-go/go-socks5/statute"
+)
+
+// NATMapping is the mapping behavior of a UDP association, see RFC 4787 section 4.1.
+type NATMapping uint8
+
+// mapping behavior defined
+const (
+	// MappingAddressAndPortDependent uses a separate outbound port for every
+	// destination address and port. This is the default.
+	MappingAddressAndPortDependent NATMapping = iota
+	// MappingAddressDependent reuses the outbound port for all the ports of
+	// a destination address.
+	MappingAddressDependent
+	// MappingEndpointIndependent reuses the outbound port for all destinations.
+	MappingEndpointIndependent
+)
+
+// NATFiltering is the filtering behavior of a UDP association, see RFC 4787 section 5.
+type NATFiltering uint8
+
+// filtering behavior defined
+const (
+	// FilteringAddressAndPortDependent only relays replies from the exact
+	// addresses and ports the client sent to. This is the default.
+	FilteringAddressAndPortDependent NATFiltering = iota
+	// FilteringAddressDependent relays replies from any port of the
+	// addresses the client sent to.
+	FilteringAddressDependent
+	// FilteringEndpointIndependent relays replies from any remote host.
+	FilteringEndpointIndependent
+)
+
+// NATBehavior is the mapping and filtering behavior of a UDP association.
+// The zero value is the symmetric behavior which uses a connected socket
+// per destination, dialed with the server's dial function. Any other
+// behavior uses unconnected UDP sockets.
+// Full-cone is {MappingEndpointIndependent, FilteringEndpointIndependent}.
+type NATBehavior struct {
+	Mapping   NATMapping
+	Filtering NATFiltering
+}
+
+func (sf NATBehavior) symmetric() bool {
+	return sf.Mapping == MappingAddressAndPortDependent && sf.Filtering == FilteringAddressAndPortDependent
+}
+
+type natBehaviorKey struct{}
+
+// UseNATBehavior selects the UDP NAT behavior for the associate request context.
+// It is intended to be called from a RuleSet.
+func UseNATBehavior(ctx context.Context, behavior NATBehavior) context.Context {
+	return context.WithValue(ctx, natBehaviorKey{}, behavior)
+}
+
+// NATBehaviorFromContext returns the UDP NAT behavior selected for the context, if any.
+func NATBehaviorFromContext(ctx context.Context) (NATBehavior, bool) {
+	behavior, ok := ctx.Value(natBehaviorKey{}).(NATBehavior)
+	return behavior, ok
+}
+
+// NATBehaviorRule wraps a RuleSet and selects the UDP NAT behavior
+// returned by Behavior for the permitted requests.
+type NATBehaviorRule struct {
+	RuleSet
+	// Behavior returns the behavior for the request, and whether to use it
+	Behavior func(req *Request) (NATBehavior, bool)
+}
+
+// Allow implement interface RuleSet
+func (sf NATBehaviorRule) Allow(ctx context.Context, req *Request) (context.Context, bool) {
+	ctx, ok := sf.RuleSet.Allow(ctx, req)
+	if ok && sf.Behavior != nil {
+		if behavior, use := sf.Behavior(req); use {
+			ctx = UseNATBehavior(ctx, behavior)
+		}
+	}
+	return ctx, ok
+}
+
+// udpResolveTTL is how long an association reuses the address an FQDN resolved to
+const udpResolveTTL = time.Minute
+
+// udpAssociation relays the datagrams of one UDP association between
+// the client and the remote hosts.
+type udpAssociation struct {
+	server   *Server
+	ctx      context.Context
+	behavior NATBehavior
+	resolver NameResolver
+	// relay is the client facing socket
+	relay net.PacketConn
+
+	mu       sync.Mutex
+	mappings map[string]*udpMapping
+	closed   bool
+	// resolved the addresses of the FQDNs sent to
+	resolved map[string]resolvedIP
+}
+
+type resolvedIP struct {
+	ip      net.IP
+	expires time.Time
+}
+
+// udpMapping is an outbound port of an association
+type udpMapping struct {
+	client net.Addr
+	// conn is connected to a single destination, for the symmetric behavior
+	conn   net.Conn
+	header []byte
+	// pconn is unconnected, for any other behavior
+	pconn net.PacketConn
+
+	mu sync.Mutex
+	// permits the filtering keys of the destinations sent to
+	permits map[string]struct{}
+	// headers the reply header of the destinations sent to,
+	// which keeps the FQDN the client used
+	headers map[string][]byte
+}
+
+func (sf *Server) newUDPAssociation(ctx context.Context, relay net.PacketConn, request *Request) *udpAssociation {
+	behavior := sf.natBehavior
+	if b, ok := NATBehaviorFromContext(ctx); ok {
+		behavior = b
+	}
+	_, resolver := sf.selectResolver(ctx, request)
+	return &udpAssociation{
+		server:   sf,
+		ctx:      ctx,
+		behavior: behavior,
+		resolver: resolver,
+		relay:    relay,
+		mappings: make(map[string]*udpMapping),
+		resolved: make(map[string]resolvedIP),
+	}
+}
+
+// forward sends the client's datagram to its destination
+func (sf *udpAssociation) forward(client *net.UDPAddr, pk statute.Datagram) error {
+	if sf.server.normalizeFQDN {
+		fqdn := pk.DstAddr.FQDN
+		if err := normalizeAddrSpec(&pk.DstAddr); err != nil {
+			sf.server.logf(LogCategoryRelay, "invalid destination[%q], %v", fqdn, err)
+			return nil
+		}
+	}
+	if sf.behavior.symmetric() {
+		return sf.forwardConnected(client, pk)
+	}
+
+	dst := &net.UDPAddr{IP: pk.DstAddr.IP, Port: pk.DstAddr.Port}
+	if pk.DstAddr.FQDN != "" {
+		var err error
+		dst.IP, err = sf.resolve(pk.DstAddr.FQDN)
+		if err != nil {
+			sf.server.logf(LogCategoryRelay, "failed to resolve destination[%v], %v", pk.DstAddr.FQDN, err)
+			return nil
+		}
+	}
+
+	key := client.String() + "--"
+	switch sf.behavior.Mapping {
+	case MappingAddressDependent:
+		key += dst.IP.String()
+	case MappingAddressAndPortDependent:
+		key += dst.String()
+	case MappingEndpointIndependent:
+	}
+
+	sf.mu.Lock()
+	m, ok := sf.mappings[key]
+	if !ok && !sf.closed {
+		pconn, err := net.ListenUDP("udp", nil)
+		if err != nil {
+			sf.mu.Unlock()
+			sf.server.logf(LogCategoryRelay, "listen udp failed, %v", err)
+			return nil
+		}
+		m = &udpMapping{
+			client:  client,
+			pconn:   pconn,
+			permits: make(map[string]struct{}),
+			headers: make(map[string][]byte),
+		}
+		sf.mappings[key] = m
+		sf.server.goFunc(func() { sf.serveMapping(key, m) })
+	}
+	sf.mu.Unlock()
+	if m == nil {
+		return net.ErrClosed
+	}
+
+	m.mu.Lock()
+	m.permits[sf.filterKey(dst)] = struct{}{}
+	if _, ok := m.headers[dst.String()]; !ok {
+		m.headers[dst.String()] = pk.Header()
+	}
+	m.mu.Unlock()
+
+	// a destination failing must not close the port shared with the others
+	if _, err := m.pconn.WriteTo(pk.Data, dst); err != nil {
+		sf.server.logf(LogCategoryRelay, "write data to remote server %s failed, %v", dst, err)
+	}
+	return nil
+}
+
+// resolve returns the address of the FQDN, cached for udpResolveTTL
+func (sf *udpAssociation) resolve(fqdn string) (net.IP, error) {
+	now := time.Now()
+	sf.mu.Lock()
+	r, ok := sf.resolved[fqdn]
+	sf.mu.Unlock()
+	if ok && now.Before(r.expires) {
+		return r.ip, nil
+	}
+
+	_, ip, err := sf.resolver.Resolve(sf.ctx, fqdn)
+	if err != nil {
+		return nil, err
+	}
+	sf.mu.Lock()
+	if len(sf.resolved) >= 256 {
+		for k, v := range sf.resolved {
+			if !now.Before(v.expires) {
+				delete(sf.resolved, k)
+			}
+		}
+	}
+	if len(sf.resolved) < 256 {
+		sf.resolved[fqdn] = resolvedIP{ip, now.Add(udpResolveTTL)}
+	}
+	sf.mu.Unlock()
+	return ip, nil
+}
+
+// forwardConnected sends the datagram over a connected socket per destination
+func (sf *udpAssociation) forwardConnected(client *net.UDPAddr, pk statute.Datagram) error {
+	key := client.String() + "--" + pk.DstAddr.String()
+
+	sf.mu.Lock()
+	m, ok := sf.mappings[key]
+	sf.mu.Unlock()
+	if !ok {
+		dial := sf.server.dial
+		if dial == nil {
+			dial = func(_ context.Context, net_, addr string) (net.Conn, error) {
+				return net.Dial(net_, addr)
+			}
+		}
+		// if the 'connection' doesn't exist, create one and store it
+		conn, err := dial(sf.ctx, "udp", pk.DstAddr.String())
+		if err != nil {
+			sf.server.logf(LogCategoryRelay, "connect to %v failed, %v", pk.DstAddr, err)
+			return nil
+		}
+		m = &udpMapping{client: client, conn: conn, header: pk.Header()}
+
+		sf.mu.Lock()
+		if sf.closed {
+			sf.mu.Unlock()
+			conn.Close()
+			return net.ErrClosed
+		}
+		sf.mappings[key] = m
+		sf.mu.Unlock()
+		sf.server.goFunc(func() { sf.serveMapping(key, m) })
+	}
+	if _, err := m.conn.Write(pk.Data); err != nil {
+		sf.server.logf(LogCategoryRelay, "write data to remote server %s failed, %v", m.conn.RemoteAddr().String(), err)
+		return err
+	}
+	return nil
+}
+
+func (sf *udpAssociation) filterKey(addr *net.UDPAddr) string {
+	switch sf.behavior.Filtering {
+	case FilteringAddressDependent:
+		return addr.IP.String()
+	case FilteringAddressAndPortDependent:
+		return addr.String()
+	case FilteringEndpointIndependent:
+	}
+	return ""
+}
+
+// serveMapping reads from remote servers and writes to the original client
+func (sf *udpAssociation) serveMapping(key string, m *udpMapping) {
+	bufPool := sf.server.bufferPool.Get()
+	defer func() {
+		if m.conn != nil {
+			m.conn.Close()
+		} else {
+			m.pconn.Close()
+		}
+		sf.mu.Lock()
+		delete(sf.mappings, key)
+		sf.mu.Unlock()
+		sf.server.bufferPool.Put(bufPool)
+	}()
+
+	for {
+		buf := bufPool[:cap(bufPool)]
+		var header []byte
+		var n int
+		var err error
+		if m.conn != nil {
+			header = m.header
+			n, err = m.conn.Read(buf)
+		} else {
+			var from net.Addr
+			n, from, err = m.pconn.ReadFrom(buf)
+			if err == nil {
+				var ok bool
+				if header, ok = m.reply(sf, from); !ok {
+					continue
+				}
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			sf.server.logf(LogCategoryRelay, "read data from remote failed, %v", err)
+			return
+		}
+
+		tmpBufPool := sf.server.bufferPool.Get()
+		proBuf := tmpBufPool
+		proBuf = append(proBuf, header...)
+		proBuf = append(proBuf, buf[:n]...)
+		if _, err := sf.relay.WriteTo(proBuf, m.client); err != nil {
+			sf.server.bufferPool.Put(tmpBufPool)
+			sf.server.logf(LogCategoryRelay, "write data to client %s failed, %v", m.client, err)
+			return
+		}
+		sf.server.bufferPool.Put(tmpBufPool)
+	}
+}
+
+// reply applies the filtering to a datagram received from addr,
+// and returns the header to relay it to the client with.
+func (sf *udpMapping) reply(assoc *udpAssociation, addr net.Addr) ([]byte, bool) {
+	from, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return nil, false
+	}
+
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if _, ok := sf.permits[assoc.filterKey(from)]; !ok {
+		return nil, false
+	}
+	if header, ok := sf.headers[from.String()]; ok {
+		return header, true
+	}
+	pk := statute.Datagram{DstAddr: statute.AddrSpec{IP: from.IP, Port: from.Port, AddrType: statute.ATYPIPv6}}
+	if ip4 := from.IP.To4(); ip4 != nil {
+		pk.DstAddr.IP, pk.DstAddr.AddrType = ip4, statute.ATYPIPv4
+	}
+	return pk.Header(), true
+}
+
+// Close closes all the outbound ports of the association
+func (sf *udpAssociation) Close() {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.closed = true
+	for _, m := range sf.mappings {
+		if m.conn != nil {
+			m.conn.Close()
+		} else {
+			m.pconn.Close()
+		}
+	}
+}
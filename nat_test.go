@@ -0,0 +1,210 @@
+package socks5
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/things-go/go-socks5/statute"
+)
+
+// associate does the udp associate handshake and returns the control
+// connection and the relay address.
+func associate(t *testing.T, proxyAddr string, client *net.UDPAddr) (net.Conn, *net.UDPAddr) {
+	conn, err := net.Dial("tcp", proxyAddr)
+	require.NoError(t, err)
+
+	_, err = conn.Write([]byte{statute.VersionSocks5, 1, statute.MethodNoAuth})
+	require.NoError(t, err)
+	reqHead := statute.Request{
+		Version: statute.VersionSocks5,
+		Command: statute.CommandAssociate,
+		DstAddr: statute.AddrSpec{IP: client.IP, Port: client.Port, AddrType: statute.ATYPIPv4},
+	}
+	_, err = conn.Write(reqHead.Bytes())
+	require.NoError(t, err)
+
+	conn.SetDeadline(time.Now().Add(time.Second)) //nolint: errcheck
+	method := make([]byte, 2)
+	_, err = conn.Read(method)
+	require.NoError(t, err)
+	rsp, err := statute.ParseReply(conn)
+	require.NoError(t, err)
+	require.Equal(t, statute.RepSuccess, rsp.Response)
+	conn.SetDeadline(time.Time{}) //nolint: errcheck
+	return conn, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: rsp.BndAddr.Port}
+}
+
+func TestNATBehavior(t *testing.T) {
+	locIP := net.IPv4(127, 0, 0, 1)
+
+	cases := []struct {
+		name     string
+		behavior NATBehavior
+		// whether a reply from a third party reaches the client
+		thirdParty bool
+	}{
+		{"symmetric", NATBehavior{}, false},
+		{"port restricted", NATBehavior{MappingEndpointIndependent, FilteringAddressAndPortDependent}, false},
+		{"full cone", NATBehavior{MappingEndpointIndependent, FilteringEndpointIndependent}, true},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			// echo server which tells its peer the address it came from
+			echo, err := net.ListenUDP("udp", &net.UDPAddr{IP: locIP})
+			require.NoError(t, err)
+			defer echo.Close()
+			outbound := make(chan *net.UDPAddr, 1)
+			go func() {
+				buf := make([]byte, 2048)
+				n, remote, err := echo.ReadFromUDP(buf)
+				if err != nil {
+					return
+				}
+				outbound <- remote
+				echo.WriteTo(buf[:n], remote) //nolint: errcheck
+			}()
+
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			srv := NewServer(WithRule(NATBehaviorRule{
+				RuleSet:  NewPermitAll(),
+				Behavior: func(*Request) (NATBehavior, bool) { return c.behavior, true },
+			}))
+			go srv.Serve(l) //nolint: errcheck
+			defer l.Close()
+
+			client, err := net.ListenUDP("udp", &net.UDPAddr{IP: locIP})
+			require.NoError(t, err)
+			defer client.Close()
+			conn, relay := associate(t, l.Addr().String(), client.LocalAddr().(*net.UDPAddr))
+			defer conn.Close()
+
+			echoAddr := echo.LocalAddr().(*net.UDPAddr)
+			pk := statute.Datagram{
+				DstAddr: statute.AddrSpec{IP: locIP, Port: echoAddr.Port, AddrType: statute.ATYPIPv4},
+				Data:    []byte("ping"),
+			}
+			_, err = client.WriteTo(pk.Bytes(), relay)
+			require.NoError(t, err)
+
+			buf := make([]byte, 2048)
+			client.SetReadDeadline(time.Now().Add(time.Second)) //nolint: errcheck
+			n, _, err := client.ReadFrom(buf)
+			require.NoError(t, err)
+			rsp, err := statute.ParseDatagram(buf[:n])
+			require.NoError(t, err)
+			assert.Equal(t, []byte("ping"), rsp.Data)
+			assert.Equal(t, echoAddr.Port, rsp.DstAddr.Port)
+
+			// a third party sends to the relay's outbound port
+			third, err := net.ListenUDP("udp", &net.UDPAddr{IP: locIP})
+			require.NoError(t, err)
+			defer third.Close()
+			_, err = third.WriteTo([]byte("hello"), <-outbound)
+			require.NoError(t, err)
+
+			client.SetReadDeadline(time.Now().Add(200 * time.Millisecond)) //nolint: errcheck
+			n, _, err = client.ReadFrom(buf)
+			if !c.thirdParty {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			rsp, err = statute.ParseDatagram(buf[:n])
+			require.NoError(t, err)
+			assert.Equal(t, []byte("hello"), rsp.Data)
+			assert.Equal(t, third.LocalAddr().(*net.UDPAddr).Port, rsp.DstAddr.Port)
+		})
+	}
+}
+
+type countingResolver struct {
+	n int32
+}
+
+func (sf *countingResolver) Resolve(ctx context.Context, _ string) (context.Context, net.IP, error) {
+	atomic.AddInt32(&sf.n, 1)
+	return ctx, net.IPv4(127, 0, 0, 1), nil
+}
+
+func TestNATBehavior_EndpointIndependentMapping(t *testing.T) {
+	locIP := net.IPv4(127, 0, 0, 1)
+
+	// two destinations, which report the address the datagram came from
+	outbound := make(chan *net.UDPAddr, 4)
+	var ports []int
+	for i := 0; i < 2; i++ {
+		dst, err := net.ListenUDP("udp", &net.UDPAddr{IP: locIP})
+		require.NoError(t, err)
+		defer dst.Close()
+		ports = append(ports, dst.LocalAddr().(*net.UDPAddr).Port)
+		go func() {
+			buf := make([]byte, 2048)
+			for {
+				_, remote, err := dst.ReadFromUDP(buf)
+				if err != nil {
+					return
+				}
+				outbound <- remote
+			}
+		}()
+	}
+
+	resolver := &countingResolver{}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := NewServer(
+		WithResolver(resolver),
+		WithNATBehavior(NATBehavior{MappingEndpointIndependent, FilteringEndpointIndependent}),
+	)
+	go srv.Serve(l) //nolint: errcheck
+	defer l.Close()
+
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: locIP})
+	require.NoError(t, err)
+	defer client.Close()
+	conn, relay := associate(t, l.Addr().String(), client.LocalAddr().(*net.UDPAddr))
+	defer conn.Close()
+
+	for _, port := range []int{ports[0], ports[1], ports[0], ports[1]} {
+		pk := statute.Datagram{
+			DstAddr: statute.AddrSpec{FQDN: "game.example", Port: port, AddrType: statute.ATYPDomain},
+			Data:    []byte("ping"),
+		}
+		_, err = client.WriteTo(pk.Bytes(), relay)
+		require.NoError(t, err)
+	}
+
+	var first *net.UDPAddr
+	for i := 0; i < 4; i++ {
+		select {
+		case remote := <-outbound:
+			if first == nil {
+				first = remote
+			}
+			assert.Equal(t, first.Port, remote.Port)
+		case <-time.After(time.Second):
+			t.Fatal("datagram not relayed")
+		}
+	}
+	// the FQDN is resolved once for the association
+	assert.Equal(t, int32(1), atomic.LoadInt32(&resolver.n))
+}
+
+func TestNATBehaviorFromContext(t *testing.T) {
+	_, ok := NATBehaviorFromContext(context.Background())
+	require.False(t, ok)
+
+	want := NATBehavior{MappingAddressDependent, FilteringAddressDependent}
+	got, ok := NATBehaviorFromContext(UseNATBehavior(context.Background(), want))
+	require.True(t, ok)
+	require.Equal(t, want, got)
+}
@@ -45,6 +45,15 @@ func WithResolver(res NameResolver) Option {
 	}
 }
 
+// WithResolverSelector can be provided to pick the resolver per request,
+// for example by the authenticated user. The resolver set by WithResolver
+// is used when it selects none.
+func WithResolverSelector(sel ResolverSelector) Option {
+	return func(s *Server) {
+		s.resolverSelector = sel
+	}
+}
+
 // WithRule is provided to enable custom logic around permitting
 // various commands. If not provided, NewPermitAll is used.
 func WithRule(rule RuleSet) Option {
@@ -53,6 +62,15 @@ func WithRule(rule RuleSet) Option {
 	}
 }
 
+// WithFQDNNormalization is used to enable or disable the normalization and
+// validation of requested domain names before resolution and rules.
+// Enabled by default, see NormalizeFQDN.
+func WithFQDNNormalization(enable bool) Option {
+	return func(s *Server) {
+		s.normalizeFQDN = enable
+	}
+}
+
 // WithRewriter can be used to transparently rewrite addresses.
 // This is invoked before the RuleSet is invoked.
 // Defaults to NoRewrite.
@@ -97,6 +115,70 @@ func WithDialAndRequest(
 	}
 }
 
+// WithPreconnectPool is used to hand out pre-warmed connections to hot
+// destinations on connect, instead of dialing. Connections from the pool
+// are made with the pool's dial function, so the pool is not used when
+// WithDialAndRequest is set.
+func WithPreconnectPool(p *PreconnectPool) Option {
+	return func(s *Server) {
+		s.preconnect = p
+	}
+}
+
+// WithTLSOrigination is used to configure the TLS client used for
+// outbound connections which the rules selected with OriginateTLS.
+func WithTLSOrigination(t *TLSOrigination) Option {
+	return func(s *Server) {
+		s.tlsOrigination = t
+	}
+}
+
+// WithNATBehavior is used to set the default UDP NAT mapping and filtering
+// behavior of udp associate. Rules can override it with UseNATBehavior.
+// Defaults to the symmetric behavior.
+func WithNATBehavior(behavior NATBehavior) Option {
+	return func(s *Server) {
+		s.natBehavior = behavior
+	}
+}
+
+// WithSharedUDPRelay makes all udp associations share the conn as their relay
+// socket, so that only one udp port has to be open. Client datagrams are
+// demultiplexed by their source address, which must be the IP of the
+// association's control connection. When the client does not tell its port
+// in the request, the first datagram from its IP binds it.
+// The caller owns conn and closes it after the server.
+func WithSharedUDPRelay(conn net.PacketConn) Option {
+	return func(s *Server) {
+		s.udpRelay = newSharedUDPRelay(conn)
+	}
+}
+
+// WithDestinationLimits is used to limit the concurrent sessions and the
+// rate of new connections to each destination of connect.
+func WithDestinationLimits(limits DestinationLimits) Option {
+	return func(s *Server) {
+		s.destLimiter = newDestinationLimiter(limits)
+	}
+}
+
+// WithSessionRecorder is used to receive a record of every finished
+// request, for example a UsageRollup.
+func WithSessionRecorder(r SessionRecorder) Option {
+	return func(s *Server) {
+		s.sessionRecorder = r
+	}
+}
+
+// WithFallback is used to serve the connections which do not start with a
+// SOCKS5 greeting, instead of failing them, for example with HTTPFallback
+// or ForwardFallback to share the port with a web server.
+func WithFallback(h FallbackHandler) Option {
+	return func(s *Server) {
+		s.fallback = h
+	}
+}
+
 // WithGPool can be provided to do custom goroutine pool.
 func WithGPool(pool GPool) Option {
 	return func(s *Server) {
@@ -124,3 +206,27 @@ func WithAssociateHandle(h func(ctx context.Context, writer io.Writer, request *
 		s.userAssociateHandle = h
 	}
 }
+
+// WithConnectHandler is used to handle a user's connect command with a ResponseWriter.
+// It takes precedence over WithConnectHandle.
+func WithConnectHandler(h HandlerFunc) Option {
+	return func(s *Server) {
+		s.connectHandler = h
+	}
+}
+
+// WithBindHandler is used to handle a user's bind command with a ResponseWriter.
+// It takes precedence over WithBindHandle.
+func WithBindHandler(h HandlerFunc) Option {
+	return func(s *Server) {
+		s.bindHandler = h
+	}
+}
+
+// WithAssociateHandler is used to handle a user's associate command with a ResponseWriter.
+// It takes precedence over WithAssociateHandle.
+func WithAssociateHandler(h HandlerFunc) Option {
+	return func(s *Server) {
+		s.associateHandler = h
+	}
+}
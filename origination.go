@@ -0,0 +1,97 @@
+package socks5
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// defaultTLSHandshakeTimeout bounds the TLS handshake with the destination
+const defaultTLSHandshakeTimeout = 10 * time.Second
+
+type originateTLSKey struct{}
+
+// OriginateTLS marks the request context so that the CONNECT handler wraps
+// the outbound connection in TLS, while the client side of the tunnel stays
+// plaintext. It is intended to be called from a RuleSet.
+func OriginateTLS(ctx context.Context) context.Context {
+	return context.WithValue(ctx, originateTLSKey{}, true)
+}
+
+// IsOriginateTLS reports whether TLS origination was selected for the context.
+func IsOriginateTLS(ctx context.Context) bool {
+	ok, _ := ctx.Value(originateTLSKey{}).(bool)
+	return ok
+}
+
+// OriginateTLSRule wraps a RuleSet and selects TLS origination
+// for the permitted requests matched by Match.
+type OriginateTLSRule struct {
+	RuleSet
+	// Match reports whether to originate TLS for the request
+	Match func(req *Request) bool
+}
+
+// Allow implement interface RuleSet
+func (sf OriginateTLSRule) Allow(ctx context.Context, req *Request) (context.Context, bool) {
+	ctx, ok := sf.RuleSet.Allow(ctx, req)
+	if ok && sf.Match != nil && sf.Match(req) {
+		ctx = OriginateTLS(ctx)
+	}
+	return ctx, ok
+}
+
+// TLSOrigination configures how outbound connections are wrapped in TLS
+// when selected with OriginateTLS.
+type TLSOrigination struct {
+	// Config is cloned for every connection, the ServerName is taken from
+	// the requested FQDN (or IP). Defaults to an empty config.
+	Config *tls.Config
+	// ClientCertificate optionally returns a client certificate for the
+	// request, for example based on the authenticated user.
+	// A nil certificate means none is sent.
+	ClientCertificate func(request *Request) (*tls.Certificate, error)
+	// HandshakeTimeout bounds the TLS handshake with the destination,
+	// defaults to 10 seconds.
+	HandshakeTimeout time.Duration
+}
+
+// client wraps conn in TLS and performs the handshake
+func (sf *TLSOrigination) client(ctx context.Context, conn net.Conn, request *Request) (*tls.Conn, error) {
+	var cfg *tls.Config
+	if sf != nil && sf.Config != nil {
+		cfg = sf.Config.Clone()
+	} else {
+		cfg = &tls.Config{} //nolint: gosec
+	}
+	if cfg.ServerName == "" {
+		if request.RawDestAddr.FQDN != "" {
+			cfg.ServerName = request.RawDestAddr.FQDN
+		} else {
+			cfg.ServerName = request.RawDestAddr.IP.String()
+		}
+	}
+	if sf != nil && sf.ClientCertificate != nil {
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := sf.ClientCertificate(request)
+			if err != nil || cert == nil {
+				return &tls.Certificate{}, err
+			}
+			return cert, nil
+		}
+	}
+
+	timeout := defaultTLSHandshakeTimeout
+	if sf != nil && sf.HandshakeTimeout > 0 {
+		timeout = sf.HandshakeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	return tlsConn, nil
+}
@@ -0,0 +1,118 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/things-go/go-socks5/bufferpool"
+	"github.com/things-go/go-socks5/statute"
+)
+
+type loopbackResolver struct{}
+
+func (loopbackResolver) Resolve(ctx context.Context, _ string) (context.Context, net.IP, error) {
+	return ctx, net.IPv4(127, 0, 0, 1), nil
+}
+
+func TestRequest_Connect_OriginateTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello %s", r.Host)
+	}))
+	defer ts.Close()
+	port := ts.Listener.Addr().(*net.TCPAddr).Port
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+
+	proxySrv := &Server{
+		rules: OriginateTLSRule{
+			RuleSet: NewPermitAll(),
+			Match:   func(req *Request) bool { return req.RawDestAddr.Port == port },
+		},
+		resolver:       loopbackResolver{},
+		tlsOrigination: &TLSOrigination{Config: &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}},
+		logger:         NewLogger(log.New(os.Stdout, "socks5: ", log.LstdFlags)),
+		bufferPool:     bufferpool.NewPool(32 * 1024),
+	}
+
+	t.Run("plaintext client", func(t *testing.T) {
+		reqHead := statute.Request{
+			Version: statute.VersionSocks5,
+			Command: statute.CommandConnect,
+			DstAddr: statute.AddrSpec{FQDN: "example.com", Port: port, AddrType: statute.ATYPDomain},
+		}
+		buf := bytes.NewBuffer(reqHead.Bytes())
+		buf.WriteString("GET / HTTP/1.0\r\nHost: example.com\r\n\r\n")
+
+		rsp := new(MockConn)
+		req, err := ParseRequest(buf)
+		require.NoError(t, err)
+		require.NoError(t, proxySrv.handleRequest(rsp, req))
+
+		out := rsp.buf.Bytes()
+		require.Equal(t, statute.RepSuccess, out[1])
+		require.Contains(t, string(out), "hello example.com")
+	})
+
+	t.Run("verification failure", func(t *testing.T) {
+		reqHead := statute.Request{
+			Version: statute.VersionSocks5,
+			Command: statute.CommandConnect,
+			DstAddr: statute.AddrSpec{FQDN: "invalid.test", Port: port, AddrType: statute.ATYPDomain},
+		}
+		rsp := new(MockConn)
+		req, err := ParseRequest(bytes.NewBuffer(reqHead.Bytes()))
+		require.NoError(t, err)
+		err = proxySrv.handleRequest(rsp, req)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "tls handshake")
+		require.Equal(t, statute.RepHostUnreachable, rsp.buf.Bytes()[1])
+	})
+}
+
+func TestRequest_Connect_OriginateTLSTimeout(t *testing.T) {
+	// a destination which accepts the connection but never speaks TLS
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		time.Sleep(time.Second)
+	}()
+
+	proxySrv := NewServer(
+		WithRule(OriginateTLSRule{RuleSet: NewPermitAll(), Match: func(*Request) bool { return true }}),
+		WithTLSOrigination(&TLSOrigination{HandshakeTimeout: 50 * time.Millisecond}),
+	)
+	lAddr := l.Addr().(*net.TCPAddr)
+	reqHead := statute.Request{
+		Version: statute.VersionSocks5,
+		Command: statute.CommandConnect,
+		DstAddr: statute.AddrSpec{IP: lAddr.IP, Port: lAddr.Port, AddrType: statute.ATYPIPv4},
+	}
+	rsp := new(MockConn)
+	req, err := ParseRequest(bytes.NewBuffer(reqHead.Bytes()))
+	require.NoError(t, err)
+
+	start := time.Now()
+	err = proxySrv.handleRequest(rsp, req)
+	require.Error(t, err)
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+	require.Equal(t, statute.RepHostUnreachable, rsp.buf.Bytes()[1])
+}
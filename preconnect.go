@@ -0,0 +1,304 @@
+package socks5
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PreconnectConfig is the configuration of a PreconnectPool
+type PreconnectConfig struct {
+	// Destinations (host:port, as dialed) which are always kept warm
+	Destinations []string
+	// Learn is the number of most requested destinations kept warm
+	// besides the configured ones, zero disables learning.
+	Learn int
+	// MinHits is the number of requests within a period needed
+	// before a destination can be learned. Defaults to 3.
+	MinHits int
+	// PerDestination is the number of idle connections kept per destination.
+	// Defaults to 2.
+	PerDestination int
+	// MaxIdle is the limit of idle connections across all destinations.
+	// Defaults to 64.
+	MaxIdle int
+	// IdleTimeout is the time an idle connection is kept before it is
+	// replaced, keep it below the destinations' idle timeouts.
+	// It is also the period request counts decay with. Defaults to 30 seconds.
+	IdleTimeout time.Duration
+	// Dial is used to open the connections. Defaults to net.Dialer.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// PreconnectPool keeps idle tcp connections open to frequently requested
+// destinations, so that a CONNECT does not wait for the dial.
+type PreconnectPool struct {
+	cfg PreconnectConfig
+
+	mu     sync.Mutex
+	dests  map[string]*preconnectDest
+	idle   int
+	closed bool
+	done   chan struct{}
+}
+
+// preconnectMaxTracked limits the destinations whose requests are counted
+const preconnectMaxTracked = 4096
+
+// preconnectProbeTimeout is how long Get waits to tell an idle
+// connection is still open
+const preconnectProbeTimeout = time.Millisecond
+
+type preconnectDest struct {
+	conns      []idleConn
+	dialing    int
+	hits       int
+	configured bool
+	learned    bool
+}
+
+type idleConn struct {
+	net.Conn
+	since time.Time
+}
+
+// NewPreconnectPool new pre-connect pool, which starts warming up the
+// configured destinations in the background. Close it when done.
+func NewPreconnectPool(cfg PreconnectConfig) *PreconnectPool {
+	if cfg.MinHits <= 0 {
+		cfg.MinHits = 3
+	}
+	if cfg.PerDestination <= 0 {
+		cfg.PerDestination = 2
+	}
+	if cfg.MaxIdle <= 0 {
+		cfg.MaxIdle = 64
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = 30 * time.Second
+	}
+	if cfg.Dial == nil {
+		dialer := &net.Dialer{}
+		cfg.Dial = dialer.DialContext
+	}
+
+	sf := &PreconnectPool{
+		cfg:   cfg,
+		dests: make(map[string]*preconnectDest),
+		done:  make(chan struct{}),
+	}
+	sf.mu.Lock()
+	for _, addr := range cfg.Destinations {
+		sf.dests[addr] = &preconnectDest{configured: true}
+		sf.fillLocked(addr)
+	}
+	sf.mu.Unlock()
+	go sf.run()
+	return sf
+}
+
+// Get returns an idle connection to addr, or nil if there is none.
+// Connections the destination closed meanwhile are skipped.
+// Every call counts as a request to addr for learning.
+func (sf *PreconnectPool) Get(addr string) net.Conn {
+	count := true
+	for {
+		conn, ok := sf.take(addr, count)
+		if !ok {
+			return nil
+		}
+		count = false
+		if conn, ok = probe(conn); ok {
+			return conn
+		}
+		conn.Close()
+	}
+}
+
+// take pops the freshest idle connection to addr, counting the request if count
+func (sf *PreconnectPool) take(addr string, count bool) (net.Conn, bool) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.closed {
+		return nil, false
+	}
+	d, ok := sf.dests[addr]
+	if !ok {
+		if !count || sf.cfg.Learn == 0 || len(sf.dests) >= preconnectMaxTracked {
+			return nil, false
+		}
+		d = &preconnectDest{}
+		sf.dests[addr] = d
+	}
+	if count {
+		d.hits++
+		if !d.learned && !d.configured && d.hits >= sf.cfg.MinHits {
+			sf.learnLocked(addr)
+		}
+	}
+
+	var conn net.Conn
+	now := time.Now()
+	for conn == nil && len(d.conns) > 0 {
+		// freshest first
+		c := d.conns[len(d.conns)-1]
+		d.conns = d.conns[:len(d.conns)-1]
+		sf.idle--
+		if now.Sub(c.since) < sf.cfg.IdleTimeout {
+			conn = c.Conn
+		} else {
+			c.Close()
+		}
+	}
+	sf.fillLocked(addr)
+	return conn, conn != nil
+}
+
+// probe reports whether the idle connection is still open. A destination
+// which speaks first may have sent data, which the returned conn replays.
+func probe(conn net.Conn) (net.Conn, bool) {
+	if err := conn.SetReadDeadline(time.Now().Add(preconnectProbeTimeout)); err != nil {
+		return conn, true
+	}
+	var b [1]byte
+	n, err := conn.Read(b[:])
+	conn.SetReadDeadline(time.Time{}) //nolint: errcheck
+	if n == 1 {
+		return &bufferedConn{conn, bufio.NewReader(io.MultiReader(bytes.NewReader(b[:]), conn))}, true
+	}
+	var ne net.Error
+	return conn, errors.As(err, &ne) && ne.Timeout()
+}
+
+// learnLocked makes addr a learned destination if it is hotter than the
+// coldest learned one, which is then forgotten.
+func (sf *PreconnectPool) learnLocked(addr string) {
+	var learned []string
+	for k, v := range sf.dests {
+		if v.learned {
+			learned = append(learned, k)
+		}
+	}
+	if len(learned) >= sf.cfg.Learn {
+		sort.Slice(learned, func(i, j int) bool { return sf.dests[learned[i]].hits < sf.dests[learned[j]].hits })
+		coldest := learned[0]
+		if sf.dests[coldest].hits >= sf.dests[addr].hits {
+			return
+		}
+		sf.evictLocked(coldest)
+		sf.dests[coldest].learned = false
+	}
+	sf.dests[addr].learned = true
+}
+
+// evictLocked closes the idle connections of addr
+func (sf *PreconnectPool) evictLocked(addr string) {
+	d := sf.dests[addr]
+	for _, c := range d.conns {
+		c.Close()
+	}
+	sf.idle -= len(d.conns)
+	d.conns = nil
+}
+
+// fillLocked dials in the background until addr has its idle connections
+func (sf *PreconnectPool) fillLocked(addr string) {
+	d := sf.dests[addr]
+	if sf.closed || d == nil || !(d.configured || d.learned) {
+		return
+	}
+	for len(d.conns)+d.dialing < sf.cfg.PerDestination && sf.idle+sf.dialingLocked() < sf.cfg.MaxIdle {
+		d.dialing++
+		go sf.dial(addr, d)
+	}
+}
+
+func (sf *PreconnectPool) dialingLocked() int {
+	n := 0
+	for _, d := range sf.dests {
+		n += d.dialing
+	}
+	return n
+}
+
+func (sf *PreconnectPool) dial(addr string, d *preconnectDest) {
+	ctx, cancel := context.WithTimeout(context.Background(), sf.cfg.IdleTimeout)
+	conn, err := sf.cfg.Dial(ctx, "tcp", addr)
+	cancel()
+
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	d.dialing--
+	if err != nil {
+		return
+	}
+	if sf.closed || !(d.configured || d.learned) || sf.dests[addr] != d {
+		conn.Close()
+		return
+	}
+	d.conns = append(d.conns, idleConn{conn, time.Now()})
+	sf.idle++
+}
+
+// run replaces expired connections and decays the request counts
+func (sf *PreconnectPool) run() {
+	interval := sf.cfg.IdleTimeout / 2
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sf.done:
+			return
+		case now := <-ticker.C:
+			sf.mu.Lock()
+			for addr, d := range sf.dests {
+				conns := d.conns[:0]
+				for _, c := range d.conns {
+					if now.Sub(c.since) < sf.cfg.IdleTimeout {
+						conns = append(conns, c)
+					} else {
+						c.Close()
+						sf.idle--
+					}
+				}
+				d.conns = conns
+				d.hits /= 2
+				if d.learned && d.hits == 0 {
+					// gone cold
+					sf.evictLocked(addr)
+					d.learned = false
+				}
+				if !d.configured && !d.learned && d.hits == 0 && d.dialing == 0 {
+					delete(sf.dests, addr)
+					continue
+				}
+				sf.fillLocked(addr)
+			}
+			sf.mu.Unlock()
+		}
+	}
+}
+
+// Close closes all idle connections and stops warming up
+func (sf *PreconnectPool) Close() error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.closed {
+		return nil
+	}
+	sf.closed = true
+	close(sf.done)
+	for addr := range sf.dests {
+		sf.evictLocked(addr)
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/things-go/go-socks5/statute"
+)
+
+func newPongListener(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 4)
+				if _, err := io.ReadFull(conn, buf); err == nil {
+					conn.Write([]byte("pong")) //nolint: errcheck
+				}
+			}()
+		}
+	}()
+	return l
+}
+
+func idleCount(p *PreconnectPool, addr string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if d, ok := p.dests[addr]; ok {
+		return len(d.conns)
+	}
+	return 0
+}
+
+func TestPreconnectPool(t *testing.T) {
+	l := newPongListener(t)
+	defer l.Close()
+	addr := l.Addr().String()
+
+	var dials int32
+	pool := NewPreconnectPool(PreconnectConfig{
+		Destinations:   []string{addr},
+		PerDestination: 2,
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	})
+	defer pool.Close()
+
+	require.Eventually(t, func() bool { return idleCount(pool, addr) == 2 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&dials))
+
+	conn := pool.Get(addr)
+	require.NotNil(t, conn)
+	defer conn.Close()
+	_, err := conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	out := make([]byte, 4)
+	_, err = io.ReadFull(conn, out)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("pong"), out)
+
+	// refilled in the background
+	require.Eventually(t, func() bool { return idleCount(pool, addr) == 2 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&dials))
+
+	// not configured and learning disabled
+	assert.Nil(t, pool.Get("127.0.0.1:1"))
+}
+
+func TestPreconnectPool_Learn(t *testing.T) {
+	hot := newPongListener(t)
+	defer hot.Close()
+
+	pool := NewPreconnectPool(PreconnectConfig{Learn: 1, MinHits: 2, PerDestination: 1, MaxIdle: 1})
+	defer pool.Close()
+
+	assert.Nil(t, pool.Get(hot.Addr().String()))
+	assert.Nil(t, pool.Get(hot.Addr().String()))
+	require.Eventually(t, func() bool { return idleCount(pool, hot.Addr().String()) == 1 }, time.Second, 5*time.Millisecond)
+
+	conn := pool.Get(hot.Addr().String())
+	require.NotNil(t, conn)
+	conn.Close()
+
+	require.NoError(t, pool.Close())
+	assert.Nil(t, pool.Get(hot.Addr().String()))
+}
+
+func TestRequest_Connect_Preconnect(t *testing.T) {
+	l := newPongListener(t)
+	defer l.Close()
+	lAddr := l.Addr().(*net.TCPAddr)
+
+	pool := NewPreconnectPool(PreconnectConfig{Destinations: []string{lAddr.String()}, PerDestination: 1})
+	defer pool.Close()
+	require.Eventually(t, func() bool { return idleCount(pool, lAddr.String()) == 1 }, time.Second, 5*time.Millisecond)
+
+	proxySrv := NewServer(
+		WithPreconnectPool(pool),
+		WithDial(func(context.Context, string, string) (net.Conn, error) {
+			return nil, errors.New("dial not expected")
+		}),
+	)
+
+	buf := bytes.NewBuffer([]byte{
+		statute.VersionSocks5, statute.CommandConnect, 0,
+		statute.ATYPIPv4, 127, 0, 0, 1, byte(lAddr.Port >> 8), byte(lAddr.Port),
+	})
+	buf.WriteString("ping")
+
+	rsp := new(MockConn)
+	req, err := ParseRequest(buf)
+	require.NoError(t, err)
+	require.NoError(t, proxySrv.handleRequest(rsp, req))
+
+	out := rsp.buf.Bytes()
+	assert.Equal(t, statute.RepSuccess, out[1])
+	assert.Equal(t, []byte("pong"), out[len(out)-4:])
+
+	// the per request dialer takes precedence over the pool
+	var dialed int32
+	proxySrv = NewServer(
+		WithPreconnectPool(pool),
+		WithDialAndRequest(func(ctx context.Context, network, addr string, _ *Request) (net.Conn, error) {
+			atomic.AddInt32(&dialed, 1)
+			return net.Dial(network, addr)
+		}),
+	)
+	require.Eventually(t, func() bool { return idleCount(pool, lAddr.String()) == 1 }, time.Second, 5*time.Millisecond)
+	buf = bytes.NewBuffer([]byte{
+		statute.VersionSocks5, statute.CommandConnect, 0,
+		statute.ATYPIPv4, 127, 0, 0, 1, byte(lAddr.Port >> 8), byte(lAddr.Port),
+	})
+	buf.WriteString("ping")
+	rsp = new(MockConn)
+	req, err = ParseRequest(buf)
+	require.NoError(t, err)
+	require.NoError(t, proxySrv.handleRequest(rsp, req))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&dialed))
+	assert.Equal(t, 1, idleCount(pool, lAddr.String()))
+}
+
+func TestPreconnectPool_Closed(t *testing.T) {
+	// a destination which closes the idle connections
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	addr := l.Addr().String()
+
+	pool := NewPreconnectPool(PreconnectConfig{Destinations: []string{addr}, PerDestination: 2})
+	defer pool.Close()
+	require.Eventually(t, func() bool { return idleCount(pool, addr) == 2 }, time.Second, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(t, pool.Get(addr))
+
+	// a tiny idle timeout does not break the pool
+	require.NoError(t, NewPreconnectPool(PreconnectConfig{IdleTimeout: 1}).Close())
+}
+
+func TestPreconnectPool_Banner(t *testing.T) {
+	// a destination which speaks first
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("220 ready\r\n")) //nolint: errcheck
+		io.Copy(io.Discard, conn)           //nolint: errcheck
+	}()
+	addr := l.Addr().String()
+
+	pool := NewPreconnectPool(PreconnectConfig{Destinations: []string{addr}, PerDestination: 1})
+	defer pool.Close()
+	require.Eventually(t, func() bool { return idleCount(pool, addr) == 1 }, time.Second, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	conn := pool.Get(addr)
+	require.NotNil(t, conn)
+	defer conn.Close()
+	out := make([]byte, 11)
+	_, err = io.ReadFull(conn, out)
+	require.NoError(t, err)
+	assert.Equal(t, "220 ready\r\n", string(out))
+}
@@ -0,0 +1,271 @@
+package socks5
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/things-go/go-socks5/statute"
+)
+
+// privacy pass defined
+const (
+	// MethodPrivacyPass is the default private method code of the
+	// PrivacyPassAuthenticator
+	MethodPrivacyPass = uint8(0x80)
+	// PrivacyPassVersion is the version of the token sub-negotiation
+	PrivacyPassVersion = uint8(0x01)
+	// PrivacyPassTokenType is the publicly verifiable token type (Blind RSA)
+	PrivacyPassTokenType = uint16(0x0002)
+)
+
+// privacyPassSaltLength the PSS salt length of the token type 0x0002, SHA-384
+const privacyPassSaltLength = 48
+
+// ErrTokenSpent is returned for a Privacy Pass token which was already redeemed
+var ErrTokenSpent = errors.New("token already spent")
+
+// SpentTokenStore records the redeemed Privacy Pass tokens, to prevent
+// double spending. It may be shared by several servers.
+type SpentTokenStore interface {
+	// Spend records the token id, it returns false if it was spent before
+	Spend(id []byte) (bool, error)
+}
+
+// MemorySpentTokens is an in-memory SpentTokenStore.
+// It grows without bound, rotate the issuer keys to reset it.
+type MemorySpentTokens struct {
+	mu    sync.Mutex
+	spent map[string]struct{}
+}
+
+// NewMemorySpentTokens new in-memory spent token store
+func NewMemorySpentTokens() *MemorySpentTokens {
+	return &MemorySpentTokens{spent: make(map[string]struct{})}
+}
+
+// Spend implement interface SpentTokenStore
+func (sf *MemorySpentTokens) Spend(id []byte) (bool, error) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if _, ok := sf.spent[string(id)]; ok {
+		return false, nil
+	}
+	sf.spent[string(id)] = struct{}{}
+	return true, nil
+}
+
+// NewPrivacyPassChallenge returns the encoded TokenChallenge (RFC 9577 section 2.1)
+// for the publicly verifiable token type. The redemption context is empty or 32 bytes.
+func NewPrivacyPassChallenge(issuerName string, redemptionContext []byte, originInfo ...string) ([]byte, error) {
+	if len(issuerName) == 0 || len(issuerName) > 0xffff {
+		return nil, errors.New("invalid issuer name length")
+	}
+	if len(redemptionContext) != 0 && len(redemptionContext) != 32 {
+		return nil, errors.New("invalid redemption context length")
+	}
+	origins := strings.Join(originInfo, ",")
+	if len(origins) > 0xffff {
+		return nil, errors.New("invalid origin info length")
+	}
+
+	b := make([]byte, 0, 2+2+len(issuerName)+1+len(redemptionContext)+2+len(origins))
+	b = append(b, byte(PrivacyPassTokenType>>8), byte(PrivacyPassTokenType))
+	b = append(b, byte(len(issuerName)>>8), byte(len(issuerName)))
+	b = append(b, issuerName...)
+	b = append(b, byte(len(redemptionContext)))
+	b = append(b, redemptionContext...)
+	b = append(b, byte(len(origins)>>8), byte(len(origins)))
+	b = append(b, origins...)
+	return b, nil
+}
+
+// PrivacyPassKeyID returns the token key id of an issuer public key: the
+// SHA-256 of its SubjectPublicKeyInfo with the RSASSA-PSS OID (RFC 9578 section 6.5).
+func PrivacyPassKeyID(pub *rsa.PublicKey) ([]byte, error) {
+	spki, err := marshalPSSPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	id := sha256.Sum256(spki)
+	return id[:], nil
+}
+
+var (
+	oidRSASSAPSS = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
+	oidMGF1      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+	oidSHA384    = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+)
+
+// marshalPSSPublicKey encodes the key as a SubjectPublicKeyInfo with the
+// RSASSA-PSS OID and the SHA-384, MGF1-SHA-384, 48 byte salt parameters.
+func marshalPSSPublicKey(pub *rsa.PublicKey) ([]byte, error) {
+	sha384, err := asn1.Marshal(pkix.AlgorithmIdentifier{Algorithm: oidSHA384})
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(struct {
+		Hash       pkix.AlgorithmIdentifier `asn1:"explicit,tag:0"`
+		MGF        pkix.AlgorithmIdentifier `asn1:"explicit,tag:1"`
+		SaltLength int                      `asn1:"explicit,tag:2"`
+	}{
+		Hash:       pkix.AlgorithmIdentifier{Algorithm: oidSHA384},
+		MGF:        pkix.AlgorithmIdentifier{Algorithm: oidMGF1, Parameters: asn1.RawValue{FullBytes: sha384}},
+		SaltLength: privacyPassSaltLength,
+	})
+	if err != nil {
+		return nil, err
+	}
+	key := x509.MarshalPKCS1PublicKey(pub)
+	return asn1.Marshal(struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidRSASSAPSS, Parameters: asn1.RawValue{FullBytes: params}},
+		PublicKey: asn1.BitString{Bytes: key, BitLength: 8 * len(key)},
+	})
+}
+
+// PrivacyPassAuthenticator authorizes the proxy use by redeeming a
+// publicly verifiable Privacy Pass token (RFC 9578 section 6), without
+// linking the session to an account.
+//
+// The token is too large for the RFC 1929 password field, so it is sent in a
+// private method sub-negotiation. The client sends
+//
+//	+-----+----------+----------+
+//	| VER |   TLEN   |  TOKEN   |
+//	+-----+----------+----------+
+//	|  1  |    2     | Variable |
+//	+-----+----------+----------+
+//
+// with VER 0x01 and TLEN big endian, and the server replies VER and STATUS,
+// 0x00 for success, as for RFC 1929.
+type PrivacyPassAuthenticator struct {
+	// Method code to negotiate, defaults to MethodPrivacyPass
+	Method uint8
+	// Challenge the encoded TokenChallenge clients must redeem tokens for
+	Challenge []byte
+	// Spent records the redeemed tokens
+	Spent SpentTokenStore
+
+	keys map[string]*rsa.PublicKey
+}
+
+// NewPrivacyPassAuthenticator new Privacy Pass authenticator accepting
+// tokens of the issuer keys for the challenge.
+func NewPrivacyPassAuthenticator(challenge []byte, spent SpentTokenStore, keys ...*rsa.PublicKey) (*PrivacyPassAuthenticator, error) {
+	if spent == nil {
+		return nil, errors.New("privacy pass requires a spent token store")
+	}
+	sf := &PrivacyPassAuthenticator{
+		Method:    MethodPrivacyPass,
+		Challenge: challenge,
+		Spent:     spent,
+		keys:      make(map[string]*rsa.PublicKey, len(keys)),
+	}
+	for _, pub := range keys {
+		id, err := PrivacyPassKeyID(pub)
+		if err != nil {
+			return nil, err
+		}
+		sf.keys[string(id)] = pub
+	}
+	return sf, nil
+}
+
+// GetCode implement interface Authenticator
+func (sf *PrivacyPassAuthenticator) GetCode() uint8 {
+	if sf.Method == 0 {
+		return MethodPrivacyPass
+	}
+	return sf.Method
+}
+
+// Authenticate implement interface Authenticator
+func (sf *PrivacyPassAuthenticator) Authenticate(reader io.Reader, writer io.Writer, _ string) (*AuthContext, error) {
+	if _, err := writer.Write([]byte{statute.VersionSocks5, sf.GetCode()}); err != nil {
+		return nil, err
+	}
+
+	head := []byte{0, 0, 0}
+	if _, err := io.ReadFull(reader, head); err != nil {
+		return nil, err
+	}
+	if head[0] != PrivacyPassVersion {
+		return nil, fmt.Errorf("unsupported privacy pass version: %v", head[0])
+	}
+	token := make([]byte, binary.BigEndian.Uint16(head[1:]))
+	if _, err := io.ReadFull(reader, token); err != nil {
+		return nil, err
+	}
+
+	keyID, err := sf.redeem(token)
+	if err != nil {
+		if _, werr := writer.Write([]byte{PrivacyPassVersion, statute.AuthFailure}); werr != nil {
+			return nil, werr
+		}
+		return nil, fmt.Errorf("%w, %v", statute.ErrUserAuthFailed, err)
+	}
+	if _, err := writer.Write([]byte{PrivacyPassVersion, statute.AuthSuccess}); err != nil {
+		return nil, err
+	}
+	return &AuthContext{sf.GetCode(), map[string]string{"token_key_id": hex.EncodeToString(keyID)}}, nil
+}
+
+// redeem verifies the token and marks it spent, it returns the token key id
+func (sf *PrivacyPassAuthenticator) redeem(token []byte) ([]byte, error) {
+	// token_type(2) nonce(32) challenge_digest(32) token_key_id(32) authenticator(Nk)
+	const inputLen = 2 + 32 + 32 + 32
+	if len(token) <= inputLen {
+		return nil, errors.New("token too short")
+	}
+	if binary.BigEndian.Uint16(token) != PrivacyPassTokenType {
+		return nil, fmt.Errorf("unsupported token type %#04x", binary.BigEndian.Uint16(token))
+	}
+	digest := sha256.Sum256(sf.Challenge)
+	if !bytes.Equal(token[34:66], digest[:]) {
+		return nil, errors.New("token for another challenge")
+	}
+	keyID := token[66:98]
+	pub, ok := sf.keys[string(keyID)]
+	if !ok {
+		return nil, errors.New("unknown token key")
+	}
+	authenticator := token[inputLen:]
+	if len(authenticator) != pub.Size() {
+		return nil, errors.New("invalid authenticator length")
+	}
+
+	hashed := sha512.New384()
+	hashed.Write(token[:inputLen]) //nolint: errcheck
+	opts := &rsa.PSSOptions{SaltLength: privacyPassSaltLength, Hash: crypto.SHA384}
+	if err := rsa.VerifyPSS(pub, crypto.SHA384, hashed.Sum(nil), authenticator, opts); err != nil {
+		return nil, err
+	}
+
+	// the nonce is unique per issuer key
+	id := make([]byte, 0, 64)
+	id = append(id, keyID...)
+	id = append(id, token[2:34]...)
+	fresh, err := sf.Spent.Spend(id)
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		return nil, ErrTokenSpent
+	}
+	return keyID, nil
+}
@@ -0,0 +1,180 @@
+package socks5
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"io"
+	"math/big"
+)
+
+// PrivacyPassIssuer is a local Privacy Pass issuer, with the client side of
+// the issuance protocol, to mint publicly verifiable tokens in tests and
+// development. It follows the RSABSSA-SHA384-PSS-Deterministic blind signature
+// protocol of RFC 9474, without constant time arithmetic, do not use it to
+// issue production tokens.
+type PrivacyPassIssuer struct {
+	key *rsa.PrivateKey
+}
+
+// NewPrivacyPassIssuer new local issuer with a fresh 2048 bit key
+func NewPrivacyPassIssuer() (*PrivacyPassIssuer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &PrivacyPassIssuer{key}, nil
+}
+
+// PublicKey returns the issuer public key, for the PrivacyPassAuthenticator
+func (sf *PrivacyPassIssuer) PublicKey() *rsa.PublicKey {
+	return &sf.key.PublicKey
+}
+
+// BlindSign signs a blinded message (RFC 9474 section 4.3)
+func (sf *PrivacyPassIssuer) BlindSign(blinded []byte) ([]byte, error) {
+	n := sf.key.N
+	m := new(big.Int).SetBytes(blinded)
+	if len(blinded) != sf.key.Size() || m.Cmp(n) >= 0 {
+		return nil, errors.New("invalid blinded message")
+	}
+	s := new(big.Int).Exp(m, sf.key.D, n)
+	// verify the signature to protect against faults leaking the key
+	if new(big.Int).Exp(s, big.NewInt(int64(sf.key.E)), n).Cmp(m) != 0 {
+		return nil, errors.New("blind signature failure")
+	}
+	return s.FillBytes(make([]byte, sf.key.Size())), nil
+}
+
+// Issue runs the issuance protocol for the challenge and returns the token.
+func (sf *PrivacyPassIssuer) Issue(challenge []byte) ([]byte, error) {
+	pub := sf.PublicKey()
+	keyID, err := PrivacyPassKeyID(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256(challenge)
+	token := make([]byte, 0, 2+32+32+32+pub.Size())
+	token = append(token, byte(PrivacyPassTokenType>>8), byte(PrivacyPassTokenType))
+	nonce := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	token = append(token, nonce...)
+	token = append(token, digest[:]...)
+	token = append(token, keyID...)
+
+	blinded, inv, err := blind(pub, token)
+	if err != nil {
+		return nil, err
+	}
+	blindSig, err := sf.BlindSign(blinded)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := finalize(pub, token, blindSig, inv)
+	if err != nil {
+		return nil, err
+	}
+	return append(token, sig...), nil
+}
+
+// blind blinds the message (RFC 9474 section 4.2), it returns the blinded
+// message and the inverse of the blind.
+func blind(pub *rsa.PublicKey, msg []byte) ([]byte, *big.Int, error) {
+	n := pub.N
+	encoded, err := emsaPSSEncode(msg, n.BitLen()-1)
+	if err != nil {
+		return nil, nil, err
+	}
+	m := new(big.Int).SetBytes(encoded)
+	if new(big.Int).GCD(nil, nil, m, n).Cmp(big.NewInt(1)) != 0 {
+		return nil, nil, errors.New("invalid message")
+	}
+
+	var r, inv *big.Int
+	for inv == nil {
+		if r, err = rand.Int(rand.Reader, n); err != nil {
+			return nil, nil, err
+		}
+		if r.Sign() > 0 {
+			inv = new(big.Int).ModInverse(r, n)
+		}
+	}
+	x := new(big.Int).Exp(r, big.NewInt(int64(pub.E)), n)
+	z := x.Mul(m, x).Mod(x, n)
+	return z.FillBytes(make([]byte, pub.Size())), inv, nil
+}
+
+// finalize unblinds the blind signature and verifies it (RFC 9474 section 4.4)
+func finalize(pub *rsa.PublicKey, msg, blindSig []byte, inv *big.Int) ([]byte, error) {
+	z := new(big.Int).SetBytes(blindSig)
+	s := z.Mul(z, inv).Mod(z, pub.N)
+	sig := s.FillBytes(make([]byte, pub.Size()))
+
+	hashed := sha512.New384()
+	hashed.Write(msg) //nolint: errcheck
+	opts := &rsa.PSSOptions{SaltLength: privacyPassSaltLength, Hash: crypto.SHA384}
+	if err := rsa.VerifyPSS(pub, crypto.SHA384, hashed.Sum(nil), sig, opts); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// emsaPSSEncode is EMSA-PSS-ENCODE of RFC 8017 section 9.1.1 with SHA-384,
+// MGF1-SHA-384 and a 48 byte salt.
+func emsaPSSEncode(msg []byte, emBits int) ([]byte, error) {
+	const hLen, sLen = 48, privacyPassSaltLength
+	emLen := (emBits + 7) / 8
+	if emLen < hLen+sLen+2 {
+		return nil, errors.New("encoding error")
+	}
+
+	mHash := sha512.New384()
+	mHash.Write(msg) //nolint: errcheck
+	salt := make([]byte, sLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	h := sha512.New384()
+	h.Write(make([]byte, 8)) //nolint: errcheck
+	h.Write(mHash.Sum(nil))  //nolint: errcheck
+	h.Write(salt)            //nolint: errcheck
+	hash := h.Sum(nil)
+
+	em := make([]byte, emLen)
+	db := em[:emLen-hLen-1]
+	db[emLen-sLen-hLen-2] = 0x01
+	copy(db[emLen-sLen-hLen-1:], salt)
+	mgf1XOR(db, hash)
+	db[0] &= 0xff >> uint(8*emLen-emBits)
+	copy(em[emLen-hLen-1:], hash)
+	em[emLen-1] = 0xbc
+	return em, nil
+}
+
+// mgf1XOR xors out with the MGF1-SHA-384 mask of seed
+func mgf1XOR(out, seed []byte) {
+	var counter [4]byte
+	for done := 0; done < len(out); {
+		h := sha512.New384()
+		h.Write(seed)       //nolint: errcheck
+		h.Write(counter[:]) //nolint: errcheck
+		for _, b := range h.Sum(nil) {
+			if done >= len(out) {
+				break
+			}
+			out[done] ^= b
+			done++
+		}
+		for i := 3; i >= 0; i-- {
+			if counter[i]++; counter[i] != 0 {
+				break
+			}
+		}
+	}
+}
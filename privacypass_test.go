@@ -0,0 +1,82 @@
+package socks5
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/things-go/go-socks5/statute"
+)
+
+func privacyPassRedeem(t *testing.T, a Authenticator, token []byte) (*AuthContext, []byte, error) {
+	t.Helper()
+	req := bytes.NewBuffer([]byte{PrivacyPassVersion, byte(len(token) >> 8), byte(len(token))})
+	req.Write(token)
+	var rsp bytes.Buffer
+	ctx, err := a.Authenticate(req, &rsp, "127.0.0.1:1080")
+	return ctx, rsp.Bytes(), err
+}
+
+func TestPrivacyPassKeyID_Encoding(t *testing.T) {
+	issuer, err := NewPrivacyPassIssuer()
+	require.NoError(t, err)
+	spki, err := marshalPSSPublicKey(issuer.PublicKey())
+	require.NoError(t, err)
+	// the RFC 9578 test vector prefix of a 2048 bit RSASSA-PSS SubjectPublicKeyInfo
+	prefix := "30820152303d06092a864886f70d01010a3030a00d300b0609608648016503040202" +
+		"a11a301806092a864886f70d010108300b0609608648016503040202a2030201300382010f00"
+	assert.Equal(t, prefix, hex.EncodeToString(spki)[:len(prefix)])
+}
+
+func TestPrivacyPassAuthenticator(t *testing.T) {
+	issuer, err := NewPrivacyPassIssuer()
+	require.NoError(t, err)
+	challenge, err := NewPrivacyPassChallenge("issuer.example", nil, "proxy.example")
+	require.NoError(t, err)
+	a, err := NewPrivacyPassAuthenticator(challenge, NewMemorySpentTokens(), issuer.PublicKey())
+	require.NoError(t, err)
+
+	token, err := issuer.Issue(challenge)
+	require.NoError(t, err)
+	assert.Len(t, token, 2+32+32+32+256)
+
+	ctx, rsp, err := privacyPassRedeem(t, a, token)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{statute.VersionSocks5, MethodPrivacyPass, PrivacyPassVersion, statute.AuthSuccess}, rsp)
+	assert.Equal(t, MethodPrivacyPass, ctx.Method)
+	keyID, err := PrivacyPassKeyID(issuer.PublicKey())
+	require.NoError(t, err)
+	assert.Equal(t, hex.EncodeToString(keyID), ctx.Payload["token_key_id"])
+
+	t.Run("double spend", func(t *testing.T) {
+		_, rsp, err := privacyPassRedeem(t, a, token)
+		require.ErrorIs(t, err, statute.ErrUserAuthFailed)
+		assert.Equal(t, []byte{statute.VersionSocks5, MethodPrivacyPass, PrivacyPassVersion, statute.AuthFailure}, rsp)
+	})
+	t.Run("forged", func(t *testing.T) {
+		forged, err := issuer.Issue(challenge)
+		require.NoError(t, err)
+		forged[len(forged)-1] ^= 1
+		_, _, err = privacyPassRedeem(t, a, forged)
+		require.ErrorIs(t, err, statute.ErrUserAuthFailed)
+	})
+	t.Run("other challenge", func(t *testing.T) {
+		other, err := NewPrivacyPassChallenge("issuer.example", nil, "other.example")
+		require.NoError(t, err)
+		tok, err := issuer.Issue(other)
+		require.NoError(t, err)
+		_, _, err = privacyPassRedeem(t, a, tok)
+		require.ErrorIs(t, err, statute.ErrUserAuthFailed)
+	})
+	t.Run("other issuer", func(t *testing.T) {
+		other, err := NewPrivacyPassIssuer()
+		require.NoError(t, err)
+		tok, err := other.Issue(challenge)
+		require.NoError(t, err)
+		_, _, err = privacyPassRedeem(t, a, tok)
+		require.ErrorIs(t, err, statute.ErrUserAuthFailed)
+	})
+}
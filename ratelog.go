@@ -0,0 +1,304 @@
+package socks5
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// LogCategory classifies the messages of the server,
+// so a RateLimitedLogger can limit each category differently.
+type LogCategory string
+
+// log category defined
+const (
+	// LogCategoryServe is the failed connections reported by Serve,
+	// such as the failed handshakes
+	LogCategoryServe LogCategory = "server"
+	// LogCategorySession is the connections served successfully
+	LogCategorySession LogCategory = "session"
+	// LogCategoryRelay is the UDP associate relay errors
+	LogCategoryRelay LogCategory = "relay"
+	// LogCategoryOther is the messages logged with Errorf
+	LogCategoryOther LogCategory = "other"
+)
+
+// CategoryLogger is a Logger which also takes the category of the messages.
+// The server logs the successful sessions only to a CategoryLogger.
+type CategoryLogger interface {
+	Logger
+	Logf(category LogCategory, format string, args ...interface{})
+}
+
+// LogPolicy is how a RateLimitedLogger limits the messages of a category.
+// Within each Interval, the first message of a key is logged, then one of
+// every Sample messages up to Burst messages. The others are suppressed and
+// summarized by a "N similar messages suppressed" message.
+type LogPolicy struct {
+	// Disabled drops all the messages of the category
+	Disabled bool
+	// Sample logs one of every Sample messages of a key, zero or one logs all
+	Sample int
+	// Burst is the number of messages of a key logged per Interval, zero for no limit
+	Burst int
+	// Interval of the limits and of the summaries, defaults to one minute
+	Interval time.Duration
+}
+
+// RateLimitedLogger is a Logger which deduplicates and samples the messages,
+// so that an attack failing many handshakes does not flood the log.
+// Messages are limited per key, the category and the message with its
+// numbers masked, so "connect to 10.0.0.1:80 failed" and
+// "connect to 10.0.0.2:80 failed" are similar.
+//
+// Summaries are logged when a key is used again, or swept in the background
+// after its Interval. Close it when done, which logs the pending summaries.
+type RateLimitedLogger struct {
+	// Default policy of the categories not in Policies
+	Default LogPolicy
+	// Policies per category
+	Policies map[LogCategory]LogPolicy
+	// MaxKeys tracked at once, then the new messages of a category
+	// share one key, defaults to 1024
+	MaxKeys int
+
+	logger Logger
+	now    func() time.Time
+
+	mu        sync.Mutex
+	keys      map[string]*logKey
+	nextSweep time.Time
+
+	once sync.Once
+	done chan struct{}
+}
+
+type logKey struct {
+	category   LogCategory
+	window     time.Time
+	seen       int
+	logged     int
+	suppressed int
+	// last suppressed message
+	last string
+}
+
+type logLine struct {
+	category LogCategory
+	msg      string
+}
+
+// NewRateLimitedLogger new rate limited logger writing to l. The categories
+// without a policy log up to 10 messages per key per minute, and the
+// successful sessions are sampled one in 100, unless set by policies.
+// It sweeps the summaries in the background until closed.
+func NewRateLimitedLogger(l Logger, policies map[LogCategory]LogPolicy) *RateLimitedLogger {
+	sf := newRateLimitedLogger(l, policies, time.Now)
+	go sf.run()
+	return sf
+}
+
+func newRateLimitedLogger(l Logger, policies map[LogCategory]LogPolicy, now func() time.Time) *RateLimitedLogger {
+	sf := &RateLimitedLogger{
+		Default:  LogPolicy{Burst: 10, Interval: time.Minute},
+		Policies: map[LogCategory]LogPolicy{LogCategorySession: {Sample: 100, Burst: 10, Interval: time.Minute}},
+		MaxKeys:  1024,
+		logger:   l,
+		now:      now,
+		keys:     make(map[string]*logKey),
+		done:     make(chan struct{}),
+	}
+	for category, p := range policies {
+		sf.Policies[category] = p
+	}
+	return sf
+}
+
+// run sweeps the keys past their interval, a few times per shortest interval
+func (sf *RateLimitedLogger) run() {
+	period := sf.policy("").Interval
+	for category := range sf.Policies {
+		if p := sf.policy(category); p.Interval < period {
+			period = p.Interval
+		}
+	}
+	period /= 4
+	if period > time.Second {
+		period = time.Second
+	} else if period < 10*time.Millisecond {
+		period = 10 * time.Millisecond
+	}
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sf.done:
+			return
+		case <-ticker.C:
+			sf.mu.Lock()
+			lines := sf.sweep(sf.now())
+			sf.mu.Unlock()
+			sf.output(lines)
+		}
+	}
+}
+
+// Close stops the background sweep and logs the pending summaries
+func (sf *RateLimitedLogger) Close() error {
+	sf.once.Do(func() { close(sf.done) })
+	sf.Flush()
+	return nil
+}
+
+// Errorf implement interface Logger, with category LogCategoryOther
+func (sf *RateLimitedLogger) Errorf(format string, args ...interface{}) {
+	sf.Logf(LogCategoryOther, format, args...)
+}
+
+// Debugf implement interface DebugLogger, the debug messages are not
+// limited and are dropped unless the underlying logger is a DebugLogger.
+func (sf *RateLimitedLogger) Debugf(format string, args ...interface{}) {
+	if l, ok := sf.logger.(DebugLogger); ok {
+		l.Debugf(format, args...)
+	}
+}
+
+// Debugging implement interface DebuggingLogger
+func (sf *RateLimitedLogger) Debugging() bool {
+	if d, ok := sf.logger.(DebuggingLogger); ok {
+		return d.Debugging()
+	}
+	_, ok := sf.logger.(DebugLogger)
+	return ok
+}
+
+// Logf implement interface CategoryLogger
+func (sf *RateLimitedLogger) Logf(category LogCategory, format string, args ...interface{}) {
+	p := sf.policy(category)
+	if p.Disabled {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	now := sf.now()
+
+	sf.mu.Lock()
+	var lines []logLine
+	if !now.Before(sf.nextSweep) {
+		lines = sf.sweep(now)
+		sf.nextSweep = now.Add(time.Second)
+	}
+	k := sf.key(category, msg, now)
+	if now.Sub(k.window) >= p.Interval {
+		lines = k.summarize(lines)
+		k.window, k.seen, k.logged = now, 0, 0
+	}
+	k.seen++
+	ok := (p.Sample <= 1 || (k.seen-1)%p.Sample == 0) && (p.Burst <= 0 || k.logged < p.Burst)
+	if ok {
+		k.logged++
+	} else {
+		k.suppressed++
+		k.last = msg
+	}
+	sf.mu.Unlock()
+
+	if ok {
+		lines = append(lines, logLine{category, msg})
+	}
+	sf.output(lines)
+}
+
+// Flush logs the summaries of the suppressed messages now
+func (sf *RateLimitedLogger) Flush() {
+	var lines []logLine
+	sf.mu.Lock()
+	for _, k := range sf.keys {
+		lines = k.summarize(lines)
+	}
+	sf.mu.Unlock()
+	sf.output(lines)
+}
+
+func (sf *RateLimitedLogger) policy(category LogCategory) LogPolicy {
+	p, ok := sf.Policies[category]
+	if !ok {
+		p = sf.Default
+	}
+	if p.Interval <= 0 {
+		p.Interval = time.Minute
+	}
+	return p
+}
+
+// key returns the state of the message's key, sf.mu must be held
+func (sf *RateLimitedLogger) key(category LogCategory, msg string, now time.Time) *logKey {
+	id := logKeyID(category, msg)
+	k, ok := sf.keys[id]
+	if ok {
+		return k
+	}
+	if maxKeys := sf.MaxKeys; len(sf.keys) >= maxKeys && maxKeys > 0 {
+		id = string(category) + "\x00"
+		if k, ok = sf.keys[id]; ok {
+			return k
+		}
+	}
+	k = &logKey{category: category, window: now}
+	sf.keys[id] = k
+	return k
+}
+
+// sweep summarizes and drops the keys past their interval, sf.mu must be held
+func (sf *RateLimitedLogger) sweep(now time.Time) []logLine {
+	var lines []logLine
+	for id, k := range sf.keys {
+		if now.Sub(k.window) >= sf.policy(k.category).Interval {
+			lines = k.summarize(lines)
+			delete(sf.keys, id)
+		}
+	}
+	return lines
+}
+
+func (sf *RateLimitedLogger) output(lines []logLine) {
+	for _, line := range lines {
+		if l, ok := sf.logger.(CategoryLogger); ok {
+			l.Logf(line.category, "%s", line.msg)
+		} else {
+			sf.logger.Errorf("%s", line.msg)
+		}
+	}
+}
+
+// summarize appends the summary of the suppressed messages, if any
+func (sf *logKey) summarize(lines []logLine) []logLine {
+	if sf.suppressed == 0 {
+		return lines
+	}
+	msg := fmt.Sprintf("%s: %d similar messages suppressed, last: %s", sf.category, sf.suppressed, sf.last)
+	sf.suppressed, sf.last = 0, ""
+	return append(lines, logLine{sf.category, msg})
+}
+
+// logKeyID returns the key of a message, its category and the message with
+// each run of digits masked.
+func logKeyID(category LogCategory, msg string) string {
+	b := make([]byte, 0, len(category)+1+len(msg))
+	b = append(b, category...)
+	b = append(b, ':')
+	digits := false
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c >= '0' && c <= '9' {
+			if !digits {
+				b = append(b, '#')
+			}
+			digits = true
+			continue
+		}
+		digits = false
+		b = append(b, c)
+	}
+	return string(b)
+}
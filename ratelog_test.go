@@ -0,0 +1,168 @@
+package socks5
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordLogger struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (sf *recordLogger) Errorf(format string, args ...interface{}) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.msgs = append(sf.msgs, fmt.Sprintf(format, args...))
+}
+
+func (sf *recordLogger) messages() []string {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return append([]string(nil), sf.msgs...)
+}
+
+func newTestRateLimitedLogger(policies map[LogCategory]LogPolicy) (*RateLimitedLogger, *recordLogger, *time.Time) {
+	out := &recordLogger{}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newRateLimitedLogger(out, policies, func() time.Time { return now })
+	return l, out, &now
+}
+
+func TestRateLimitedLogger_Burst(t *testing.T) {
+	l, out, now := newTestRateLimitedLogger(map[LogCategory]LogPolicy{
+		LogCategoryServe: {Burst: 2, Interval: time.Minute},
+	})
+
+	for i := 0; i < 5; i++ {
+		l.Logf(LogCategoryServe, "server: handshake from 10.0.0.%d:%d failed", i, 40000+i)
+	}
+	l.Logf(LogCategoryServe, "server: unsupported version")
+	assert.Equal(t, []string{
+		"server: handshake from 10.0.0.0:40000 failed",
+		"server: handshake from 10.0.0.1:40001 failed",
+		"server: unsupported version",
+	}, out.messages())
+
+	*now = now.Add(time.Minute)
+	l.Logf(LogCategoryServe, "server: handshake from 10.0.0.9:40009 failed")
+	assert.Equal(t, []string{
+		"server: 3 similar messages suppressed, last: server: handshake from 10.0.0.4:40004 failed",
+		"server: handshake from 10.0.0.9:40009 failed",
+	}, out.messages()[3:])
+}
+
+func TestRateLimitedLogger_Sample(t *testing.T) {
+	l, out, _ := newTestRateLimitedLogger(nil)
+
+	for i := 0; i < 250; i++ {
+		l.Logf(LogCategorySession, "server: 127.0.0.1:%d served", 1000+i)
+	}
+	assert.Equal(t, []string{
+		"server: 127.0.0.1:1000 served",
+		"server: 127.0.0.1:1100 served",
+		"server: 127.0.0.1:1200 served",
+	}, out.messages())
+
+	l.Flush()
+	assert.Equal(t, "session: 247 similar messages suppressed, last: server: 127.0.0.1:1249 served", out.messages()[3])
+	l.Flush()
+	assert.Len(t, out.messages(), 4)
+}
+
+func TestRateLimitedLogger_Policies(t *testing.T) {
+	l, out, now := newTestRateLimitedLogger(map[LogCategory]LogPolicy{
+		LogCategoryRelay:   {Disabled: true},
+		LogCategorySession: {},
+	})
+	l.MaxKeys = 2
+
+	l.Logf(LogCategoryRelay, "listen udp failed")
+	l.Logf(LogCategorySession, "server: served")
+	l.Logf(LogCategorySession, "server: served")
+	assert.Equal(t, []string{"server: served", "server: served"}, out.messages())
+
+	// the new keys share one key once MaxKeys are tracked
+	for i := 0; i < 12; i++ {
+		l.Errorf("error %c", 'a'+i)
+	}
+	assert.Len(t, out.messages(), 2+1+10)
+
+	// idle keys are swept with their summaries
+	*now = now.Add(2 * time.Minute)
+	l.Logf(LogCategorySession, "server: served")
+	assert.Equal(t, []string{
+		"other: 1 similar messages suppressed, last: error l",
+		"server: served",
+	}, out.messages()[13:])
+	assert.Len(t, l.keys, 1)
+}
+
+func TestRateLimitedLogger_Server(t *testing.T) {
+	out := &recordLogger{}
+	logger := NewRateLimitedLogger(out, map[LogCategory]LogPolicy{
+		LogCategoryServe: {Burst: 3, Interval: time.Hour},
+	})
+	defer logger.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go NewServer(WithLogger(logger)).Serve(l) //nolint: errcheck
+
+	for i := 0; i < 10; i++ {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+		conn.Write([]byte{0x04, 0x01, 0x00}) //nolint: errcheck
+		conn.Read(make([]byte, 1))           //nolint: errcheck
+		conn.Close()
+	}
+	require.Eventually(t, func() bool {
+		logger.mu.Lock()
+		defer logger.mu.Unlock()
+		for _, k := range logger.keys {
+			if k.suppressed == 7 {
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Len(t, out.messages(), 3)
+
+	logger.Flush()
+	assert.Contains(t, out.messages()[3], "server: 7 similar messages suppressed")
+}
+
+func TestRateLimitedLogger_Debug(t *testing.T) {
+	srv := NewServer(WithLogger(newRateLimitedLogger(&recordLogger{}, nil, time.Now)))
+	_, ok := srv.debugLogger()
+	assert.False(t, ok)
+
+	srv = NewServer(WithLogger(newRateLimitedLogger(NewDebugLogger(log.New(io.Discard, "", 0)), nil, time.Now)))
+	_, ok = srv.debugLogger()
+	assert.True(t, ok)
+}
+
+func TestRateLimitedLogger_PeriodicSummary(t *testing.T) {
+	out := &recordLogger{}
+	l := NewRateLimitedLogger(out, map[LogCategory]LogPolicy{
+		LogCategoryServe: {Burst: 1, Interval: 50 * time.Millisecond},
+	})
+	defer l.Close()
+
+	for i := 0; i < 5; i++ {
+		l.Logf(LogCategoryServe, "server: handshake from 10.0.0.%d failed", i)
+	}
+	require.Len(t, out.messages(), 1)
+
+	// the burst stopped, the summary comes without further calls
+	require.Eventually(t, func() bool { return len(out.messages()) == 2 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "server: 4 similar messages suppressed, last: server: handshake from 10.0.0.4 failed", out.messages()[1])
+}
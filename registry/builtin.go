@@ -0,0 +1,76 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/things-go/go-socks5"
+)
+
+func init() {
+	RegisterAuthenticator("noauth", func(struct{}) (socks5.Authenticator, error) {
+		return socks5.NoAuthAuthenticator{}, nil
+	})
+	RegisterAuthenticator("userpass", func(cfg struct {
+		Credentials *Component `json:"credentials"`
+	}) (socks5.Authenticator, error) {
+		if cfg.Credentials == nil {
+			return nil, errors.New("registry: userpass requires credentials")
+		}
+		cs, err := NewCredentialStore(*cfg.Credentials)
+		if err != nil {
+			return nil, err
+		}
+		return socks5.UserPassAuthenticator{Credentials: cs}, nil
+	})
+
+	RegisterCredentialStore("static", func(cfg struct {
+		Users map[string]string `json:"users"`
+	}) (socks5.CredentialStore, error) {
+		return socks5.StaticCredentials(cfg.Users), nil
+	})
+	RegisterCredentialStore("introspection", func(cfg struct {
+		Endpoint     string   `json:"endpoint"`
+		ClientID     string   `json:"client_id"`
+		ClientSecret string   `json:"client_secret"`
+		Scopes       []string `json:"scopes"`
+	}) (socks5.CredentialStore, error) {
+		if cfg.Endpoint == "" {
+			return nil, errors.New("registry: introspection requires endpoint")
+		}
+		return socks5.NewIntrospectionCredentials(cfg.Endpoint, cfg.ClientID, cfg.ClientSecret, cfg.Scopes...), nil
+	})
+
+	RegisterRuleSet("permit", func(cfg struct {
+		Connect   bool `json:"connect"`
+		Bind      bool `json:"bind"`
+		Associate bool `json:"associate"`
+	}) (socks5.RuleSet, error) {
+		return &socks5.PermitCommand{
+			EnableConnect:   cfg.Connect,
+			EnableBind:      cfg.Bind,
+			EnableAssociate: cfg.Associate,
+		}, nil
+	})
+
+	RegisterResolver("dns", func(struct{}) (socks5.NameResolver, error) {
+		return socks5.DNSResolver{}, nil
+	})
+
+	RegisterDialer("direct", func(cfg struct {
+		// Timeout like "5s", optional
+		Timeout string `json:"timeout"`
+	}) (Dialer, error) {
+		d := &net.Dialer{}
+		if cfg.Timeout != "" {
+			timeout, err := time.ParseDuration(cfg.Timeout)
+			if err != nil {
+				return nil, fmt.Errorf("registry: direct dialer timeout, %w", err)
+			}
+			d.Timeout = timeout
+		}
+		return d.DialContext, nil
+	})
+}
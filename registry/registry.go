@@ -0,0 +1,254 @@
+// Package registry is a registry of named server components, so a server
+// can be built from a configuration file. Authenticators, credential
+// stores, rule sets, resolvers, rewriters and dialers register a factory by
+// name, which receives its typed config decoded from JSON:
+//
+//	{"auth": [{"type": "userpass", "credentials": {"type": "static", "users": {"foo": "bar"}}}]}
+//
+// Third-party packages register their own components from an init function.
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/things-go/go-socks5"
+)
+
+// Kind of component
+type Kind string
+
+// kind defined
+const (
+	KindAuthenticator   Kind = "authenticator"
+	KindCredentialStore Kind = "credentials"
+	KindRuleSet         Kind = "rules"
+	KindResolver        Kind = "resolver"
+	KindRewriter        Kind = "rewriter"
+	KindDialer          Kind = "dialer"
+)
+
+// Dialer is used for dialing out, see socks5.WithDial
+type Dialer func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Component is a configured component, the "type" field names its factory
+// and the whole object is decoded as the factory's config.
+type Component struct {
+	Type   string
+	Config json.RawMessage
+}
+
+// UnmarshalJSON implement interface json.Unmarshaler
+func (sf *Component) UnmarshalJSON(b []byte) error {
+	var head struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(b, &head); err != nil {
+		return err
+	}
+	if head.Type == "" {
+		return fmt.Errorf("registry: component without type")
+	}
+	sf.Type, sf.Config = head.Type, append(json.RawMessage(nil), b...)
+	return nil
+}
+
+// MarshalJSON implement interface json.Marshaler
+func (sf Component) MarshalJSON() ([]byte, error) {
+	if len(sf.Config) != 0 {
+		return sf.Config, nil
+	}
+	return json.Marshal(map[string]string{"type": sf.Type})
+}
+
+type factory func(config json.RawMessage) (interface{}, error)
+
+var (
+	mu        sync.RWMutex
+	factories = make(map[Kind]map[string]factory)
+)
+
+// register makes a factory available by kind and name, the config is
+// decoded into C. It panics if called twice with the same kind and name.
+func register[C any, T any](kind Kind, name string, f func(cfg C) (T, error)) {
+	if f == nil {
+		panic("registry: nil factory for " + string(kind) + " " + name)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if factories[kind] == nil {
+		factories[kind] = make(map[string]factory)
+	}
+	if _, dup := factories[kind][name]; dup {
+		panic("registry: register called twice for " + string(kind) + " " + name)
+	}
+	factories[kind][name] = func(config json.RawMessage) (interface{}, error) {
+		var cfg C
+		if len(config) != 0 {
+			if err := json.Unmarshal(config, &cfg); err != nil {
+				return nil, fmt.Errorf("registry: %s %q config, %w", kind, name, err)
+			}
+		}
+		return f(cfg)
+	}
+}
+
+// build builds the component of kind
+func build[T any](kind Kind, c Component) (T, error) {
+	var zero T
+
+	mu.RLock()
+	f, ok := factories[kind][c.Type]
+	mu.RUnlock()
+	if !ok {
+		return zero, fmt.Errorf("registry: unknown %s %q", kind, c.Type)
+	}
+	v, err := f(c.Config)
+	if err != nil {
+		return zero, err
+	}
+	t, ok := v.(T)
+	if !ok {
+		return zero, fmt.Errorf("registry: %s %q returned no component", kind, c.Type)
+	}
+	return t, nil
+}
+
+// Names returns the registered names of a kind, sorted
+func Names(kind Kind) []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(factories[kind]))
+	for name := range factories[kind] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RegisterAuthenticator registers an authenticator factory
+func RegisterAuthenticator[C any](name string, f func(cfg C) (socks5.Authenticator, error)) {
+	register(KindAuthenticator, name, f)
+}
+
+// RegisterCredentialStore registers a credential store factory
+func RegisterCredentialStore[C any](name string, f func(cfg C) (socks5.CredentialStore, error)) {
+	register(KindCredentialStore, name, f)
+}
+
+// RegisterRuleSet registers a rule set factory
+func RegisterRuleSet[C any](name string, f func(cfg C) (socks5.RuleSet, error)) {
+	register(KindRuleSet, name, f)
+}
+
+// RegisterResolver registers a name resolver factory
+func RegisterResolver[C any](name string, f func(cfg C) (socks5.NameResolver, error)) {
+	register(KindResolver, name, f)
+}
+
+// RegisterRewriter registers an address rewriter factory
+func RegisterRewriter[C any](name string, f func(cfg C) (socks5.AddressRewriter, error)) {
+	register(KindRewriter, name, f)
+}
+
+// RegisterDialer registers a dialer factory
+func RegisterDialer[C any](name string, f func(cfg C) (Dialer, error)) {
+	register(KindDialer, name, f)
+}
+
+// NewAuthenticator builds a configured authenticator
+func NewAuthenticator(c Component) (socks5.Authenticator, error) {
+	return build[socks5.Authenticator](KindAuthenticator, c)
+}
+
+// NewCredentialStore builds a configured credential store
+func NewCredentialStore(c Component) (socks5.CredentialStore, error) {
+	return build[socks5.CredentialStore](KindCredentialStore, c)
+}
+
+// NewRuleSet builds a configured rule set
+func NewRuleSet(c Component) (socks5.RuleSet, error) {
+	return build[socks5.RuleSet](KindRuleSet, c)
+}
+
+// NewResolver builds a configured name resolver
+func NewResolver(c Component) (socks5.NameResolver, error) {
+	return build[socks5.NameResolver](KindResolver, c)
+}
+
+// NewRewriter builds a configured address rewriter
+func NewRewriter(c Component) (socks5.AddressRewriter, error) {
+	return build[socks5.AddressRewriter](KindRewriter, c)
+}
+
+// NewDialer builds a configured dialer
+func NewDialer(c Component) (Dialer, error) {
+	return build[Dialer](KindDialer, c)
+}
+
+// Config is the component configuration of a server
+type Config struct {
+	Auth        []Component `json:"auth"`
+	Credentials *Component  `json:"credentials"`
+	Rules       *Component  `json:"rules"`
+	Resolver    *Component  `json:"resolver"`
+	Rewriter    *Component  `json:"rewriter"`
+	Dialer      *Component  `json:"dialer"`
+}
+
+// Options builds the configured components as server options
+func (sf *Config) Options() ([]socks5.Option, error) {
+	var opts []socks5.Option
+
+	if len(sf.Auth) != 0 {
+		methods := make([]socks5.Authenticator, 0, len(sf.Auth))
+		for _, c := range sf.Auth {
+			a, err := NewAuthenticator(c)
+			if err != nil {
+				return nil, err
+			}
+			methods = append(methods, a)
+		}
+		opts = append(opts, socks5.WithAuthMethods(methods))
+	}
+	if sf.Credentials != nil {
+		cs, err := NewCredentialStore(*sf.Credentials)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, socks5.WithCredential(cs))
+	}
+	if sf.Rules != nil {
+		rules, err := NewRuleSet(*sf.Rules)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, socks5.WithRule(rules))
+	}
+	if sf.Resolver != nil {
+		res, err := NewResolver(*sf.Resolver)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, socks5.WithResolver(res))
+	}
+	if sf.Rewriter != nil {
+		rew, err := NewRewriter(*sf.Rewriter)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, socks5.WithRewriter(rew))
+	}
+	if sf.Dialer != nil {
+		dial, err := NewDialer(*sf.Dialer)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, socks5.WithDial(dial))
+	}
+	return opts, nil
+}
@@ -0,0 +1,92 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/things-go/go-socks5"
+	"github.com/things-go/go-socks5/statute"
+)
+
+type prefixRewriter struct{ prefix string }
+
+func (sf prefixRewriter) Rewrite(ctx context.Context, request *socks5.Request) (context.Context, *statute.AddrSpec) {
+	return ctx, request.DestAddr
+}
+
+func init() {
+	RegisterRewriter("test-prefix", func(cfg struct {
+		Prefix string `json:"prefix"`
+	}) (socks5.AddressRewriter, error) {
+		if cfg.Prefix == "" {
+			return nil, errors.New("prefix required")
+		}
+		return prefixRewriter{cfg.Prefix}, nil
+	})
+	RegisterRewriter("test-nil", func(struct{}) (socks5.AddressRewriter, error) {
+		return nil, nil
+	})
+}
+
+func TestConfig_Options(t *testing.T) {
+	assert.Contains(t, Names(KindRewriter), "test-prefix")
+
+	var cfg Config
+	err := json.Unmarshal([]byte(`{
+		"auth": [{"type": "noauth"}, {"type": "userpass", "credentials": {"type": "static", "users": {"foo": "bar"}}}],
+		"rules": {"type": "permit", "connect": true},
+		"resolver": {"type": "dns"},
+		"rewriter": {"type": "test-prefix", "prefix": "x"},
+		"dialer": {"type": "direct", "timeout": "5s"}
+	}`), &cfg)
+	require.NoError(t, err)
+	opts, err := cfg.Options()
+	require.NoError(t, err)
+	assert.Len(t, opts, 5)
+	require.NotNil(t, socks5.NewServer(opts...))
+
+	auth, err := NewAuthenticator(cfg.Auth[1])
+	require.NoError(t, err)
+	up, ok := auth.(socks5.UserPassAuthenticator)
+	require.True(t, ok)
+	assert.True(t, up.Credentials.Valid("foo", "bar", ""))
+
+	rules, err := NewRuleSet(*cfg.Rules)
+	require.NoError(t, err)
+	assert.Equal(t, &socks5.PermitCommand{EnableConnect: true}, rules)
+
+	rew, err := NewRewriter(*cfg.Rewriter)
+	require.NoError(t, err)
+	assert.Equal(t, prefixRewriter{"x"}, rew)
+}
+
+func TestConfig_Errors(t *testing.T) {
+	for _, s := range []string{
+		`{"auth": [{"type": "nope"}]}`,
+		`{"auth": [{"type": "userpass"}]}`,
+		`{"rules": {"type": "permit", "connect": "yes"}}`,
+		`{"dialer": {"type": "direct", "timeout": "soon"}}`,
+		`{"rewriter": {"type": "test-nil"}}`,
+	} {
+		var cfg Config
+		require.NoError(t, json.Unmarshal([]byte(s), &cfg), s)
+		_, err := cfg.Options()
+		assert.Error(t, err, s)
+	}
+
+	var cfg Config
+	assert.Error(t, json.Unmarshal([]byte(`{"resolver": {"name": "dns"}}`), &cfg))
+}
+
+func TestRegister_Duplicate(t *testing.T) {
+	assert.Panics(t, func() {
+		RegisterResolver("dns", func(struct{}) (socks5.NameResolver, error) {
+			return socks5.DNSResolver{}, nil
+		})
+	})
+}
@@ -0,0 +1,224 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/things-go/go-socks5/statute"
+)
+
+// sharedUDPRelay is a single client facing udp socket shared by all
+// udp associations. Datagrams are demultiplexed to the owning association
+// by their source address, which must be the IP of the association's
+// control connection.
+type sharedUDPRelay struct {
+	conn net.PacketConn
+	once sync.Once
+
+	mu sync.Mutex
+	// sessions the associations bound to a client address
+	sessions map[string]*udpAssociation
+	// pending the associations, by client IP, which did not tell their
+	// client port and wait for their first datagram, oldest first
+	pending map[string][]*udpAssociation
+	// queues the datagrams waiting to be forwarded by each association,
+	// so a slow resolve or dial only delays its own association
+	queues map[*udpAssociation]*relayQueue
+}
+
+// relayQueue is the datagrams waiting to be forwarded by an association
+type relayQueue struct {
+	ch chan relayDatagram
+	// started whether the forwarder of the queue runs
+	started bool
+}
+
+// relayDatagram is a datagram queued to an association
+type relayDatagram struct {
+	client *net.UDPAddr
+	pk     statute.Datagram
+	// buf holds the datagram, it is returned to the buffer pool once forwarded
+	buf []byte
+}
+
+// relayQueueSize is the datagrams queued per association, then they are dropped
+const relayQueueSize = 64
+
+func newSharedUDPRelay(conn net.PacketConn) *sharedUDPRelay {
+	return &sharedUDPRelay{
+		conn:     conn,
+		sessions: make(map[string]*udpAssociation),
+		pending:  make(map[string][]*udpAssociation),
+		queues:   make(map[*udpAssociation]*relayQueue),
+	}
+}
+
+// register binds the association to the client address, a zero port is
+// bound by the first datagram from the client's IP.
+func (sf *sharedUDPRelay) register(assoc *udpAssociation, client *net.UDPAddr) error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if client.Port == 0 {
+		ip := client.IP.String()
+		sf.pending[ip] = append(sf.pending[ip], assoc)
+	} else {
+		if _, ok := sf.sessions[client.String()]; ok {
+			return fmt.Errorf("udp client address %s already associated", client)
+		}
+		sf.sessions[client.String()] = assoc
+	}
+	sf.queues[assoc] = &relayQueue{ch: make(chan relayDatagram, relayQueueSize)}
+	return nil
+}
+
+// unregister removes the association from the relay
+func (sf *sharedUDPRelay) unregister(assoc *udpAssociation) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if queue, ok := sf.queues[assoc]; ok {
+		close(queue.ch)
+		delete(sf.queues, assoc)
+	}
+	for k, v := range sf.sessions {
+		if v == assoc {
+			delete(sf.sessions, k)
+		}
+	}
+	for ip, list := range sf.pending {
+		for i, v := range list {
+			if v == assoc {
+				list = append(list[:i], list[i+1:]...)
+				break
+			}
+		}
+		if len(list) == 0 {
+			delete(sf.pending, ip)
+		} else {
+			sf.pending[ip] = list
+		}
+	}
+}
+
+// lookup returns the association owning the client address
+func (sf *sharedUDPRelay) lookup(client *net.UDPAddr) *udpAssociation {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if assoc, ok := sf.sessions[client.String()]; ok {
+		return assoc
+	}
+	ip := client.IP.String()
+	list := sf.pending[ip]
+	if len(list) == 0 {
+		return nil
+	}
+	assoc := list[0]
+	if len(list) == 1 {
+		delete(sf.pending, ip)
+	} else {
+		sf.pending[ip] = list[1:]
+	}
+	sf.sessions[client.String()] = assoc
+	return assoc
+}
+
+// serve reads from the shared socket and forwards to the owning association
+func (sf *sharedUDPRelay) serve(srv *Server) {
+	bufPool := srv.bufferPool.Get()
+	defer srv.bufferPool.Put(bufPool)
+	for {
+		n, addr, err := sf.conn.ReadFrom(bufPool[:cap(bufPool)])
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		srcAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		assoc := sf.lookup(srcAddr)
+		if assoc == nil {
+			continue
+		}
+		buf := srv.bufferPool.Get()
+		pk, err := statute.ParseDatagram(append(buf, bufPool[:n]...))
+		if err != nil {
+			srv.bufferPool.Put(buf)
+			continue
+		}
+		if !sf.enqueue(srv, assoc, relayDatagram{srcAddr, pk, buf}) {
+			srv.bufferPool.Put(buf)
+		}
+	}
+}
+
+// enqueue queues the datagram to the association, starting its forwarder.
+// It returns false if the datagram was dropped.
+func (sf *sharedUDPRelay) enqueue(srv *Server, assoc *udpAssociation, d relayDatagram) bool {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	queue, ok := sf.queues[assoc]
+	if !ok {
+		return false
+	}
+	if !queue.started {
+		queue.started = true
+		srv.goFunc(func() { sf.forward(srv, assoc, queue.ch) })
+	}
+	select {
+	case queue.ch <- d:
+		return true
+	default:
+		return false
+	}
+}
+
+// forward sends the queued datagrams of an association until it is unregistered
+func (sf *sharedUDPRelay) forward(srv *Server, assoc *udpAssociation, queue chan relayDatagram) {
+	for d := range queue {
+		err := assoc.forward(d.client, d.pk)
+		srv.bufferPool.Put(d.buf)
+		if err != nil {
+			sf.unregister(assoc)
+			assoc.Close()
+		}
+	}
+}
+
+// handleSharedAssociate is used to handle a udp associate command on the shared relay
+func (sf *Server) handleSharedAssociate(ctx context.Context, writer io.Writer, request *Request) error {
+	ctrl, ok := request.RemoteAddr.(*net.TCPAddr)
+	if !ok {
+		if err := SendReply(writer, statute.RepServerFailure, nil); err != nil {
+			return fmt.Errorf("failed to send reply, %v", err)
+		}
+		return fmt.Errorf("unknown client address %v", request.RemoteAddr)
+	}
+
+	// strict source binding: the client IP is the control connection's IP,
+	// only the port is taken from the request.
+	client := &net.UDPAddr{IP: ctrl.IP, Port: request.DestAddr.Port}
+	assoc := sf.newUDPAssociation(ctx, sf.udpRelay.conn, request)
+	if err := sf.udpRelay.register(assoc, client); err != nil {
+		if err := SendReply(writer, statute.RepServerFailure, nil); err != nil {
+			return fmt.Errorf("failed to send reply, %v", err)
+		}
+		return err
+	}
+	defer func() {
+		sf.udpRelay.unregister(assoc)
+		assoc.Close()
+	}()
+	sf.udpRelay.once.Do(func() { sf.goFunc(func() { sf.udpRelay.serve(sf) }) })
+
+	// send BND.ADDR and BND.PORT, client used
+	if err := SendReply(writer, statute.RepSuccess, sf.udpRelay.conn.LocalAddr()); err != nil {
+		return fmt.Errorf("failed to send reply, %v", err)
+	}
+	return sf.waitAssociateDone(request.Reader)
+}
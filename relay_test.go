@@ -0,0 +1,164 @@
+package socks5
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/things-go/go-socks5/statute"
+)
+
+func TestSharedUDPRelay(t *testing.T) {
+	locIP := net.IPv4(127, 0, 0, 1)
+
+	echo, err := net.ListenUDP("udp", &net.UDPAddr{IP: locIP})
+	require.NoError(t, err)
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, remote, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteTo(buf[:n], remote) //nolint: errcheck
+		}
+	}()
+	echoAddr := echo.LocalAddr().(*net.UDPAddr)
+
+	relayConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: locIP})
+	require.NoError(t, err)
+	defer relayConn.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := NewServer(WithSharedUDPRelay(relayConn))
+	go srv.Serve(l) //nolint: errcheck
+	defer l.Close()
+
+	// two associations, one telling its port and one not
+	client1, err := net.ListenUDP("udp", &net.UDPAddr{IP: locIP})
+	require.NoError(t, err)
+	defer client1.Close()
+	client2, err := net.ListenUDP("udp", &net.UDPAddr{IP: locIP})
+	require.NoError(t, err)
+	defer client2.Close()
+
+	conn1, relay1 := associate(t, l.Addr().String(), client1.LocalAddr().(*net.UDPAddr))
+	defer conn1.Close()
+	conn2, relay2 := associate(t, l.Addr().String(), &net.UDPAddr{IP: net.IPv4zero})
+	defer conn2.Close()
+	require.Equal(t, relayConn.LocalAddr().(*net.UDPAddr).Port, relay1.Port)
+	require.Equal(t, relay1.Port, relay2.Port)
+
+	exchange := func(client *net.UDPConn, msg string) {
+		pk := statute.Datagram{
+			DstAddr: statute.AddrSpec{IP: locIP, Port: echoAddr.Port, AddrType: statute.ATYPIPv4},
+			Data:    []byte(msg),
+		}
+		_, err := client.WriteTo(pk.Bytes(), relay1)
+		require.NoError(t, err)
+
+		buf := make([]byte, 2048)
+		client.SetReadDeadline(time.Now().Add(time.Second)) //nolint: errcheck
+		n, from, err := client.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.Equal(t, relay1.Port, from.(*net.UDPAddr).Port)
+		rsp, err := statute.ParseDatagram(buf[:n])
+		require.NoError(t, err)
+		assert.Equal(t, msg, string(rsp.Data))
+	}
+	exchange(client1, "one")
+	exchange(client2, "two")
+	exchange(client1, "three")
+
+	// a source which no association owns is dropped
+	stranger, err := net.ListenUDP("udp", &net.UDPAddr{IP: locIP})
+	require.NoError(t, err)
+	defer stranger.Close()
+	pk := statute.Datagram{
+		DstAddr: statute.AddrSpec{IP: locIP, Port: echoAddr.Port, AddrType: statute.ATYPIPv4},
+		Data:    []byte("nope"),
+	}
+	_, err = stranger.WriteTo(pk.Bytes(), relay1)
+	require.NoError(t, err)
+	stranger.SetReadDeadline(time.Now().Add(200 * time.Millisecond)) //nolint: errcheck
+	_, _, err = stranger.ReadFrom(make([]byte, 2048))
+	require.Error(t, err)
+}
+
+func TestSharedUDPRelay_SlowDial(t *testing.T) {
+	locIP := net.IPv4(127, 0, 0, 1)
+
+	echo, err := net.ListenUDP("udp", &net.UDPAddr{IP: locIP})
+	require.NoError(t, err)
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, remote, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteTo(buf[:n], remote) //nolint: errcheck
+		}
+	}()
+	echoAddr := echo.LocalAddr().(*net.UDPAddr)
+
+	relayConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: locIP})
+	require.NoError(t, err)
+	defer relayConn.Close()
+
+	// dials to the blackhole port hang until the test ends
+	blackhole := make(chan struct{})
+	defer close(blackhole)
+	srv := NewServer(WithSharedUDPRelay(relayConn), WithDial(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr == "127.0.0.1:9" {
+			<-blackhole
+			return nil, context.Canceled
+		}
+		return net.Dial(network, addr)
+	}))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(l) //nolint: errcheck
+	defer l.Close()
+
+	slow, err := net.ListenUDP("udp", &net.UDPAddr{IP: locIP})
+	require.NoError(t, err)
+	defer slow.Close()
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: locIP})
+	require.NoError(t, err)
+	defer client.Close()
+	conn1, relay := associate(t, l.Addr().String(), slow.LocalAddr().(*net.UDPAddr))
+	defer conn1.Close()
+	conn2, _ := associate(t, l.Addr().String(), client.LocalAddr().(*net.UDPAddr))
+	defer conn2.Close()
+
+	pk := statute.Datagram{
+		DstAddr: statute.AddrSpec{IP: locIP, Port: 9, AddrType: statute.ATYPIPv4},
+		Data:    []byte("stuck"),
+	}
+	_, err = slow.WriteTo(pk.Bytes(), relay)
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	// the other association is not held up by the hanging dial
+	pk = statute.Datagram{
+		DstAddr: statute.AddrSpec{IP: locIP, Port: echoAddr.Port, AddrType: statute.ATYPIPv4},
+		Data:    []byte("fast"),
+	}
+	_, err = client.WriteTo(pk.Bytes(), relay)
+	require.NoError(t, err)
+	buf := make([]byte, 2048)
+	client.SetReadDeadline(time.Now().Add(time.Second)) //nolint: errcheck
+	n, _, err := client.ReadFrom(buf)
+	require.NoError(t, err)
+	rsp, err := statute.ParseDatagram(buf[:n])
+	require.NoError(t, err)
+	assert.Equal(t, "fast", string(rsp.Data))
+}
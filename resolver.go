@@ -21,3 +21,54 @@ func (d DNSResolver) Resolve(ctx context.Context, name string) (context.Context,
 	}
 	return ctx, addr.IP, err
 }
+
+// DefaultResolverName is the name recorded for the server's resolver
+const DefaultResolverName = "default"
+
+// ResolverSelector picks the NameResolver of a request instead of the
+// server's resolver, for example by the authenticated user. It is invoked
+// before resolution, so the request has no DestAddr yet.
+type ResolverSelector interface {
+	Select(ctx context.Context, req *Request) (name string, resolver NameResolver, ok bool)
+}
+
+// ResolverRule selects Resolver, recorded as Name, for the requests Match reports
+type ResolverRule struct {
+	Name     string
+	Resolver NameResolver
+	Match    func(ctx context.Context, req *Request) bool
+}
+
+// ResolverRules is a ResolverSelector which selects the resolver
+// of the first matching rule
+type ResolverRules []ResolverRule
+
+// Select implement interface ResolverSelector
+func (sf ResolverRules) Select(ctx context.Context, req *Request) (string, NameResolver, bool) {
+	for _, r := range sf {
+		if r.Match == nil || r.Match(ctx, req) {
+			return r.Name, r.Resolver, true
+		}
+	}
+	return "", nil, false
+}
+
+// MatchUsers returns a ResolverRule match for the requests authenticated
+// as one of the users
+func MatchUsers(users ...string) func(ctx context.Context, req *Request) bool {
+	return func(_ context.Context, req *Request) bool {
+		if req.AuthContext == nil {
+			return false
+		}
+		user, ok := req.AuthContext.Payload["username"]
+		if !ok {
+			return false
+		}
+		for _, u := range users {
+			if u == user {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -1,11 +1,15 @@
 package socks5
 
 import (
+	"bytes"
 	"context"
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/things-go/go-socks5/statute"
 )
 
 func TestDNSResolver(t *testing.T) {
@@ -16,3 +20,64 @@ func TestDNSResolver(t *testing.T) {
 	require.NoError(t, err)
 	assert.True(t, addr.IsLoopback())
 }
+
+type staticResolver net.IP
+
+func (sf staticResolver) Resolve(ctx context.Context, _ string) (context.Context, net.IP, error) {
+	return ctx, net.IP(sf), nil
+}
+
+func TestResolverRules(t *testing.T) {
+	internal := staticResolver(net.IPv4(10, 0, 0, 1))
+	filtered := staticResolver(net.IPv4(192, 0, 2, 1))
+	rules := ResolverRules{
+		{Name: "internal", Resolver: internal, Match: MatchUsers("alice", "bob")},
+		{Name: "filtered", Resolver: filtered},
+	}
+
+	var seen *Request
+	srv := NewServer(
+		WithResolverSelector(rules),
+		WithRule(ruleFunc(func(req *Request) bool {
+			seen = req
+			return false
+		})),
+	)
+
+	for _, c := range []struct {
+		user     string
+		resolver string
+		ip       net.IP
+	}{
+		{"alice", "internal", net.IP(internal)},
+		{"guest", "filtered", net.IP(filtered)},
+	} {
+		reqHead := statute.Request{
+			Version: statute.VersionSocks5,
+			Command: statute.CommandConnect,
+			DstAddr: statute.AddrSpec{FQDN: "intranet.example", Port: 80, AddrType: statute.ATYPDomain},
+		}
+		req, err := ParseRequest(bytes.NewBuffer(reqHead.Bytes()))
+		require.NoError(t, err)
+		req.AuthContext = &AuthContext{statute.MethodUserPassAuth, map[string]string{"username": c.user}}
+		require.Error(t, srv.handleRequest(new(MockConn), req))
+
+		assert.Equal(t, c.resolver, seen.Resolver)
+		assert.True(t, c.ip.Equal(seen.DestAddr.IP))
+	}
+
+	// no selector, the server's resolver
+	srv = NewServer(WithResolver(internal), WithRule(ruleFunc(func(req *Request) bool {
+		seen = req
+		return false
+	})))
+	reqHead := statute.Request{
+		Version: statute.VersionSocks5,
+		Command: statute.CommandConnect,
+		DstAddr: statute.AddrSpec{FQDN: "intranet.example", Port: 80, AddrType: statute.ATYPDomain},
+	}
+	req, err := ParseRequest(bytes.NewBuffer(reqHead.Bytes()))
+	require.NoError(t, err)
+	require.Error(t, srv.handleRequest(new(MockConn), req))
+	assert.Equal(t, DefaultResolverName, seen.Resolver)
+}
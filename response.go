@@ -0,0 +1,225 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/things-go/go-socks5/statute"
+)
+
+// response writer error defined
+var (
+	ErrReplied      = errors.New("reply already sent")
+	ErrHijacked     = errors.New("connection has been hijacked")
+	ErrNotSupported = errors.New("not supported by the underlying connection")
+)
+
+// ResponseWriter is used by a command handler to answer the client.
+// Writes go to the client, after the reply.
+type ResponseWriter interface {
+	io.Writer
+	// Reply sends the reply with the status and bind address, it can only be
+	// sent once, except the second reply of a BIND once the peer connected.
+	// The address type is derived from the address if not set.
+	Reply(rep uint8, bindAddr statute.AddrSpec) error
+	// Hijack lets the handler take over the connection, the server will
+	// not close it. Data the client sent after the request is still read
+	// through Request.Reader.
+	Hijack() (net.Conn, error)
+	// SetDeadline, SetReadDeadline and SetWriteDeadline set the deadlines
+	// of the underlying connection
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	// CloseWrite half-closes the connection to the client
+	CloseWrite() error
+	// Status returns the reply sent, and whether one was sent
+	Status() (uint8, bool)
+	// BytesWritten returns the bytes written to the client, excluding the reply
+	BytesWritten() int64
+	// BytesRead returns the bytes read from the client after the request
+	BytesRead() int64
+}
+
+// HandlerFunc is used to handle a user's command with a ResponseWriter
+type HandlerFunc func(ctx context.Context, w ResponseWriter, request *Request) error
+
+type response struct {
+	written int64 // atomic, keep first for alignment
+	read    int64 // atomic
+
+	w  io.Writer
+	mu sync.Mutex
+	// rep the last reply sent, valid if replied
+	rep      uint8
+	replied  bool
+	hijacked bool
+	// replies left, two for a BIND
+	replies int
+	// onReply if set, is called with every reply sent
+	onReply func(rep statute.Reply)
+}
+
+func newResponse(w io.Writer) *response {
+	return &response{w: w, replies: 1}
+}
+
+// setCommand sets the command of the request, which decides how many replies are sent
+func (sf *response) setCommand(cmd byte) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if cmd == statute.CommandBind && !sf.replied {
+		sf.replies = 2
+	}
+}
+
+// Write implement interface io.Writer
+func (sf *response) Write(b []byte) (int, error) {
+	sf.mu.Lock()
+	hijacked := sf.hijacked
+	sf.mu.Unlock()
+	if hijacked {
+		return 0, ErrHijacked
+	}
+	n, err := sf.w.Write(b)
+	atomic.AddInt64(&sf.written, int64(n))
+	return n, err
+}
+
+// ReadFrom implement interface io.ReaderFrom, so the copies to the client
+// keep the fast path of the underlying connection, such as splice.
+func (sf *response) ReadFrom(r io.Reader) (int64, error) {
+	if sf.isHijacked() {
+		return 0, ErrHijacked
+	}
+	var n int64
+	var err error
+	if rf, ok := sf.w.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(sf.w, r)
+	}
+	atomic.AddInt64(&sf.written, n)
+	return n, err
+}
+
+// Reply implement interface ResponseWriter
+func (sf *response) Reply(rep uint8, bindAddr statute.AddrSpec) error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.hijacked {
+		return ErrHijacked
+	}
+	// the second BIND reply only follows a successful first one
+	if sf.replies == 0 || (sf.replied && sf.rep != statute.RepSuccess) {
+		return ErrReplied
+	}
+
+	if bindAddr.AddrType == 0 {
+		switch {
+		case bindAddr.FQDN != "":
+			bindAddr.AddrType = statute.ATYPDomain
+		case bindAddr.IP.To4() != nil:
+			bindAddr.AddrType = statute.ATYPIPv4
+		case bindAddr.IP.To16() != nil:
+			bindAddr.AddrType = statute.ATYPIPv6
+		default:
+			bindAddr.AddrType, bindAddr.IP = statute.ATYPIPv4, net.IPv4zero
+		}
+	}
+	rsp := statute.Reply{
+		Version:  statute.VersionSocks5,
+		Response: rep,
+		BndAddr:  bindAddr,
+	}
+	sf.rep, sf.replied = rep, true
+	sf.replies--
+	if sf.onReply != nil {
+		sf.onReply(rsp)
+	}
+	_, err := sf.w.Write(rsp.Bytes())
+	return err
+}
+
+// Hijack implement interface ResponseWriter
+func (sf *response) Hijack() (net.Conn, error) {
+	conn, ok := sf.w.(net.Conn)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.hijacked {
+		return nil, ErrHijacked
+	}
+	sf.hijacked = true
+	return conn, nil
+}
+
+// SetDeadline implement interface ResponseWriter
+func (sf *response) SetDeadline(t time.Time) error {
+	if d, ok := sf.w.(interface{ SetDeadline(time.Time) error }); ok {
+		return d.SetDeadline(t)
+	}
+	return ErrNotSupported
+}
+
+// SetReadDeadline implement interface ResponseWriter
+func (sf *response) SetReadDeadline(t time.Time) error {
+	if d, ok := sf.w.(interface{ SetReadDeadline(time.Time) error }); ok {
+		return d.SetReadDeadline(t)
+	}
+	return ErrNotSupported
+}
+
+// SetWriteDeadline implement interface ResponseWriter
+func (sf *response) SetWriteDeadline(t time.Time) error {
+	if d, ok := sf.w.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		return d.SetWriteDeadline(t)
+	}
+	return ErrNotSupported
+}
+
+// CloseWrite implement interface ResponseWriter
+func (sf *response) CloseWrite() error {
+	if cw, ok := sf.w.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return ErrNotSupported
+}
+
+// Status implement interface ResponseWriter
+func (sf *response) Status() (uint8, bool) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.rep, sf.replied
+}
+
+// BytesWritten implement interface ResponseWriter
+func (sf *response) BytesWritten() int64 { return atomic.LoadInt64(&sf.written) }
+
+// BytesRead implement interface ResponseWriter
+func (sf *response) BytesRead() int64 { return atomic.LoadInt64(&sf.read) }
+
+func (sf *response) isHijacked() bool {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.hijacked
+}
+
+// countReader counts the bytes read into the response
+type countReader struct {
+	io.Reader
+	rsp *response
+}
+
+func (sf countReader) Read(b []byte) (int, error) {
+	n, err := sf.Reader.Read(b)
+	atomic.AddInt64(&sf.rsp.read, int64(n))
+	return n, err
+}
@@ -0,0 +1,160 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/things-go/go-socks5/statute"
+)
+
+func TestResponseWriter_Reply(t *testing.T) {
+	var rw ResponseWriter
+	srv := NewServer(WithConnectHandler(func(_ context.Context, w ResponseWriter, request *Request) error {
+		rw = w
+		if err := w.Reply(statute.RepSuccess, statute.AddrSpec{FQDN: "proxy.example", Port: 1080}); err != nil {
+			return err
+		}
+		require.ErrorIs(t, w.Reply(statute.RepServerFailure, statute.AddrSpec{}), ErrReplied)
+		require.ErrorIs(t, w.SetDeadline(time.Now()), ErrNotSupported)
+		_, err := io.Copy(w, request.Reader)
+		return err
+	}))
+
+	reqHead := statute.Request{
+		Version: statute.VersionSocks5,
+		Command: statute.CommandConnect,
+		DstAddr: statute.AddrSpec{IP: net.IPv4(127, 0, 0, 1), Port: 80, AddrType: statute.ATYPIPv4},
+	}
+	buf := bytes.NewBuffer(reqHead.Bytes())
+	buf.WriteString("echo")
+
+	rsp := new(MockConn)
+	req, err := ParseRequest(buf)
+	require.NoError(t, err)
+	require.NoError(t, srv.handleRequest(rsp, req))
+
+	expected := []byte{
+		statute.VersionSocks5, statute.RepSuccess, 0,
+		statute.ATYPDomain, 13, 'p', 'r', 'o', 'x', 'y', '.', 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0x04, 0x38,
+		'e', 'c', 'h', 'o',
+	}
+	assert.Equal(t, expected, rsp.buf.Bytes())
+
+	rep, ok := rw.Status()
+	assert.True(t, ok)
+	assert.Equal(t, statute.RepSuccess, rep)
+	assert.Equal(t, int64(4), rw.BytesWritten())
+	assert.Equal(t, int64(4), rw.BytesRead())
+}
+
+func TestResponseWriter_Hijack(t *testing.T) {
+	hijacked := make(chan net.Conn, 1)
+	srv := NewServer(WithConnectHandler(func(_ context.Context, w ResponseWriter, _ *Request) error {
+		if err := SendReply(w, statute.RepSuccess, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}); err != nil {
+			return err
+		}
+		conn, err := w.Hijack()
+		if err != nil {
+			return err
+		}
+		_, err = w.Write([]byte("x"))
+		require.ErrorIs(t, err, ErrHijacked)
+		hijacked <- conn
+		return nil
+	}))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go srv.Serve(l) //nolint: errcheck
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	reqHead := statute.Request{
+		Version: statute.VersionSocks5,
+		Command: statute.CommandConnect,
+		DstAddr: statute.AddrSpec{IP: net.IPv4(127, 0, 0, 1), Port: 80, AddrType: statute.ATYPIPv4},
+	}
+	_, err = conn.Write(append([]byte{statute.VersionSocks5, 1, statute.MethodNoAuth}, reqHead.Bytes()...))
+	require.NoError(t, err)
+
+	// the server keeps the connection open after the handler returned
+	serverConn := <-hijacked
+	defer serverConn.Close()
+	time.Sleep(10 * time.Millisecond)
+	_, err = serverConn.Write([]byte("late"))
+	require.NoError(t, err)
+
+	conn.SetDeadline(time.Now().Add(time.Second)) //nolint: errcheck
+	out := make([]byte, 2+10+4)
+	_, err = io.ReadFull(conn, out)
+	require.NoError(t, err)
+	assert.Equal(t, statute.RepSuccess, out[3])
+	assert.Equal(t, []byte("late"), out[12:])
+}
+
+func TestResponseWriter_LegacyHandle(t *testing.T) {
+	rsp := new(MockConn)
+	srv := NewServer(WithBindHandle(func(_ context.Context, w io.Writer, _ *Request) error {
+		require.Equal(t, rsp, w)
+		bindAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+		if err := SendReply(w, statute.RepSuccess, bindAddr); err != nil {
+			return err
+		}
+		return SendReply(w, statute.RepSuccess, bindAddr)
+	}))
+
+	reqHead := statute.Request{
+		Version: statute.VersionSocks5,
+		Command: statute.CommandBind,
+		DstAddr: statute.AddrSpec{IP: net.IPv4(127, 0, 0, 1), Port: 80, AddrType: statute.ATYPIPv4},
+	}
+	req, err := ParseRequest(bytes.NewBuffer(reqHead.Bytes()))
+	require.NoError(t, err)
+	require.NoError(t, srv.handleRequest(rsp, req))
+	assert.Len(t, rsp.buf.Bytes(), 2*10)
+}
+
+func TestResponseWriter_BindReplies(t *testing.T) {
+	srv := NewServer(WithBindHandler(func(_ context.Context, w ResponseWriter, _ *Request) error {
+		bindAddr := statute.AddrSpec{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+		require.NoError(t, w.Reply(statute.RepSuccess, bindAddr))
+		require.NoError(t, w.Reply(statute.RepSuccess, bindAddr))
+		return w.Reply(statute.RepSuccess, bindAddr)
+	}))
+
+	reqHead := statute.Request{
+		Version: statute.VersionSocks5,
+		Command: statute.CommandBind,
+		DstAddr: statute.AddrSpec{IP: net.IPv4(127, 0, 0, 1), Port: 80, AddrType: statute.ATYPIPv4},
+	}
+	rsp := new(MockConn)
+	req, err := ParseRequest(bytes.NewBuffer(reqHead.Bytes()))
+	require.NoError(t, err)
+	require.ErrorIs(t, srv.handleRequest(rsp, req), ErrReplied)
+	assert.Len(t, rsp.buf.Bytes(), 2*10)
+}
+
+func TestResponseWriter_ReadFrom(t *testing.T) {
+	server, client := tcpPair(t)
+	rsp := newResponse(server)
+	_, ok := interface{}(rsp).(io.ReaderFrom)
+	require.True(t, ok)
+
+	require.NoError(t, NewServer().Proxy(rsp, bytes.NewBufferString("hello")))
+	assert.Equal(t, int64(5), rsp.BytesWritten())
+
+	// Proxy half-closed the connection
+	out, err := io.ReadAll(client)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(out))
+}
@@ -10,6 +10,7 @@ import (
 	"net"
 
 	"github.com/things-go/go-socks5/bufferpool"
+	"github.com/things-go/go-socks5/dissector"
 	"github.com/things-go/go-socks5/statute"
 )
 
@@ -32,9 +33,15 @@ type Server struct {
 	// resolver can be provided to do custom name resolution.
 	// Defaults to DNSResolver if not provided.
 	resolver NameResolver
+	// resolverSelector can be provided to pick the resolver per request,
+	// falls back to resolver.
+	resolverSelector ResolverSelector
 	// rules is provided to enable custom logic around permitting
 	// various commands. If not provided, NewPermitAll is used.
 	rules RuleSet
+	// normalizeFQDN whether requested domain names are normalized and
+	// validated before resolution and rules, see NormalizeFQDN.
+	normalizeFQDN bool
 	// rewriter can be used to transparently rewrite addresses.
 	// This is invoked before the RuleSet is invoked.
 	// Defaults to NoRewrite.
@@ -49,6 +56,22 @@ type Server struct {
 	dial func(ctx context.Context, network, addr string) (net.Conn, error)
 	// Optional function for dialing out with the access of request detail.
 	dialWithRequest func(ctx context.Context, network, addr string, request *Request) (net.Conn, error)
+	// preconnect if set, provides pre-warmed connections for connect
+	preconnect *PreconnectPool
+	// tlsOrigination is used to wrap outbound connections in TLS
+	// when selected by the rules with OriginateTLS.
+	tlsOrigination *TLSOrigination
+	// natBehavior is the default UDP NAT behavior of udp associate,
+	// rules can select another one with UseNATBehavior.
+	natBehavior NATBehavior
+	// udpRelay if set, is the single udp socket shared by all udp associations
+	udpRelay *sharedUDPRelay
+	// destLimiter if set, limits the connects to each destination
+	destLimiter *destinationLimiter
+	// fallback if set, serves the connections which are not SOCKS5
+	fallback FallbackHandler
+	// sessionRecorder if set, receives a record of every finished request
+	sessionRecorder SessionRecorder
 	// buffer pool
 	bufferPool bufferpool.BufPool
 	// goroutine pool
@@ -57,16 +80,21 @@ type Server struct {
 	userConnectHandle   func(ctx context.Context, writer io.Writer, request *Request) error
 	userBindHandle      func(ctx context.Context, writer io.Writer, request *Request) error
 	userAssociateHandle func(ctx context.Context, writer io.Writer, request *Request) error
+	// user's handler, take precedence over user's handle
+	connectHandler   HandlerFunc
+	bindHandler      HandlerFunc
+	associateHandler HandlerFunc
 }
 
 // NewServer creates a new Server
 func NewServer(opts ...Option) *Server {
 	srv := &Server{
-		authMethods: []Authenticator{},
-		bufferPool:  bufferpool.NewPool(32 * 1024),
-		resolver:    DNSResolver{},
-		rules:       NewPermitAll(),
-		logger:      NewLogger(log.New(io.Discard, "socks5: ", log.LstdFlags)),
+		authMethods:   []Authenticator{},
+		bufferPool:    bufferpool.NewPool(32 * 1024),
+		resolver:      DNSResolver{},
+		rules:         NewPermitAll(),
+		normalizeFQDN: true,
+		logger:        NewLogger(log.New(io.Discard, "socks5: ", log.LstdFlags)),
 	}
 
 	for _, opt := range opts {
@@ -103,7 +131,9 @@ func (sf *Server) Serve(l net.Listener) error {
 		}
 		sf.goFunc(func() {
 			if err := sf.ServeConn(conn); err != nil {
-				sf.logger.Errorf("server: %v", err)
+				sf.logf(LogCategoryServe, "server: %v", err)
+			} else if l, ok := sf.logger.(CategoryLogger); ok {
+				l.Logf(LogCategorySession, "server: %v served", conn.RemoteAddr())
 			}
 		})
 	}
@@ -113,14 +143,39 @@ func (sf *Server) Serve(l net.Listener) error {
 func (sf *Server) ServeConn(conn net.Conn) error {
 	var authContext *AuthContext
 
-	defer conn.Close()
+	rsp := newResponse(conn)
+	l, debug := sf.debugLogger()
+	if debug {
+		rsp.onReply = func(rep statute.Reply) {
+			l.Debugf("server: %v %v", conn.RemoteAddr(), dissector.Reply(rep))
+		}
+	}
+	defer func() {
+		if !rsp.isHijacked() {
+			conn.Close()
+		}
+	}()
 
 	bufConn := bufio.NewReader(conn)
 
+	// Hand the connections which are not SOCKS5 to the fallback
+	if sf.fallback != nil {
+		if b, err := bufConn.Peek(1); err == nil && b[0] != statute.VersionSocks5 {
+			c, err := rsp.Hijack()
+			if err != nil {
+				return err
+			}
+			return sf.fallback(&bufferedConn{c, bufConn})
+		}
+	}
+
 	mr, err := statute.ParseMethodRequest(bufConn)
 	if err != nil {
 		return err
 	}
+	if debug {
+		l.Debugf("server: %v %v", conn.RemoteAddr(), dissector.MethodRequest(mr))
+	}
 	if mr.Ver != statute.VersionSocks5 {
 		return statute.ErrNotSupportVersion
 	}
@@ -139,17 +194,20 @@ func (sf *Server) ServeConn(conn net.Conn) error {
 	request, err := ParseRequest(bufConn)
 	if err != nil {
 		if errors.Is(err, statute.ErrUnrecognizedAddrType) {
-			if err := SendReply(conn, statute.RepAddrTypeNotSupported, nil); err != nil {
+			if err := SendReply(rsp, statute.RepAddrTypeNotSupported, nil); err != nil {
 				return fmt.Errorf("failed to send reply %w", err)
 			}
 		}
 		return fmt.Errorf("failed to read destination address, %w", err)
 	}
+	if debug {
+		l.Debugf("server: %v %v", conn.RemoteAddr(), dissector.Request(request.Request))
+	}
 
 	if request.Request.Command != statute.CommandConnect &&
 		request.Request.Command != statute.CommandBind &&
 		request.Request.Command != statute.CommandAssociate {
-		if err := SendReply(conn, statute.RepCommandNotSupported, nil); err != nil {
+		if err := SendReply(rsp, statute.RepCommandNotSupported, nil); err != nil {
 			return fmt.Errorf("failed to send reply, %v", err)
 		}
 		return fmt.Errorf("unrecognized command[%d]", request.Request.Command)
@@ -159,7 +217,7 @@ func (sf *Server) ServeConn(conn net.Conn) error {
 	request.LocalAddr = conn.LocalAddr()
 	request.RemoteAddr = conn.RemoteAddr()
 	// Process the client request
-	return sf.handleRequest(conn, request)
+	return sf.handleRequest(rsp, request)
 }
 
 // authenticate is used to handle connection authentication
@@ -167,17 +225,57 @@ func (sf *Server) authenticate(conn io.Writer, bufConn io.Reader,
 	userAddr string, methods []byte) (*AuthContext, error) {
 	// Select a usable method
 	for _, auth := range sf.authMethods {
+		if ca, ok := auth.(ConnAuthenticator); ok {
+			if c, ok := conn.(net.Conn); ok {
+				authContext, err := ca.AuthenticateConn(c, bufConn, userAddr, methods)
+				if errors.Is(err, ErrAuthNotApplicable) {
+					continue
+				}
+				if l, ok := sf.debugLogger(); ok && err == nil {
+					l.Debugf("server: %v %v", userAddr,
+						dissector.MethodReply(statute.MethodReply{Ver: statute.VersionSocks5, Method: authContext.Method}))
+				}
+				return authContext, err
+			}
+		}
 		for _, method := range methods {
 			if auth.GetCode() == method {
+				if l, ok := sf.debugLogger(); ok {
+					l.Debugf("server: %v %v", userAddr,
+						dissector.MethodReply(statute.MethodReply{Ver: statute.VersionSocks5, Method: method}))
+				}
 				return auth.Authenticate(bufConn, conn, userAddr)
 			}
 		}
 	}
 	// No usable method found
+	if l, ok := sf.debugLogger(); ok {
+		l.Debugf("server: %v %v", userAddr,
+			dissector.MethodReply(statute.MethodReply{Ver: statute.VersionSocks5, Method: statute.MethodNoAcceptable}))
+	}
 	conn.Write([]byte{statute.VersionSocks5, statute.MethodNoAcceptable}) //nolint: errcheck
 	return nil, statute.ErrNoSupportedAuth
 }
 
+// debugLogger returns the logger if it takes debug messages,
+// to check before building them
+func (sf *Server) debugLogger() (DebugLogger, bool) {
+	l, ok := sf.logger.(DebugLogger)
+	if d, is := sf.logger.(DebuggingLogger); is {
+		ok = d.Debugging()
+	}
+	return l, ok
+}
+
+// logf logs the message with its category if the logger is a CategoryLogger
+func (sf *Server) logf(category LogCategory, format string, args ...interface{}) {
+	if l, ok := sf.logger.(CategoryLogger); ok {
+		l.Logf(category, format, args...)
+		return
+	}
+	sf.logger.Errorf(format, args...)
+}
+
 func (sf *Server) goFunc(f func()) {
 	if sf.gPool == nil || sf.gPool.Submit(f) != nil {
 		go f()
@@ -331,3 +331,24 @@ func TestNoSupportedAuth_Server(t *testing.T) {
 
 	assert.Equal(t, []byte{statute.VersionSocks5, statute.MethodNoAcceptable}, rsp.Bytes())
 }
+
+func TestServer_DebugLog(t *testing.T) {
+	out := new(bytes.Buffer)
+	srv := NewServer(WithLogger(NewDebugLogger(log.New(out, "", 0))), WithRule(&PermitCommand{}))
+
+	server, client := tcpPair(t)
+	reqHead := statute.Request{
+		Version: statute.VersionSocks5,
+		Command: statute.CommandConnect,
+		DstAddr: statute.AddrSpec{IP: net.IPv4(127, 0, 0, 1), Port: 80, AddrType: statute.ATYPIPv4},
+	}
+	_, err := client.Write(append([]byte{statute.VersionSocks5, 1, statute.MethodNoAuth}, reqHead.Bytes()...))
+	require.NoError(t, err)
+	require.Error(t, srv.ServeConn(server))
+
+	logs := out.String()
+	assert.Contains(t, logs, "C->S method request: ver=5 methods=[no-auth]")
+	assert.Contains(t, logs, "S->C method reply: ver=5 method=no-auth")
+	assert.Contains(t, logs, "C->S request: ver=5 cmd=connect")
+	assert.Contains(t, logs, "S->C reply: ver=5 rep=connection not allowed by ruleset")
+}
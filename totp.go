@@ -0,0 +1,128 @@
+package socks5
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" //nolint: gosec
+	"crypto/subtle"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TOTPCredentials wraps a CredentialStore with a TOTP (RFC 6238) second factor.
+// Clients send "password+123456" in the password field, the code is verified
+// against the user's secret and can not be reused once accepted.
+type TOTPCredentials struct {
+	// Credentials verifies the password part
+	Credentials CredentialStore
+	// Secrets per user raw shared secrets, users without secret are refused
+	Secrets map[string][]byte
+	// Digits of the code, defaults to 6
+	Digits int
+	// Period of a time step, defaults to 30 seconds
+	Period time.Duration
+	// Window is the number of time steps accepted before and after the
+	// current one, to allow for clock skew.
+	Window int
+
+	now  func() time.Time
+	mu   sync.Mutex
+	used map[string]int64
+}
+
+// NewTOTPCredentials new TOTP credential store with a window of one step
+func NewTOTPCredentials(cs CredentialStore, secrets map[string][]byte) *TOTPCredentials {
+	return &TOTPCredentials{
+		Credentials: cs,
+		Secrets:     secrets,
+		Window:      1,
+	}
+}
+
+// Valid implement interface CredentialStore
+func (sf *TOTPCredentials) Valid(user, password, userAddr string) bool {
+	_, ok := sf.Identity(user, password, userAddr)
+	return ok
+}
+
+// Identity implement interface IdentityCredentialStore
+func (sf *TOTPCredentials) Identity(user, password, userAddr string) (map[string]string, bool) {
+	idx := strings.LastIndexByte(password, '+')
+	if idx < 0 {
+		return nil, false
+	}
+	password, code := password[:idx], password[idx+1:]
+	secret, ok := sf.Secrets[user]
+	if !ok || len(code) != sf.digits() {
+		return nil, false
+	}
+
+	var identity map[string]string
+	if ic, is := sf.Credentials.(IdentityCredentialStore); is {
+		identity, ok = ic.Identity(user, password, userAddr)
+	} else {
+		ok = sf.Credentials.Valid(user, password, userAddr)
+	}
+	if !ok {
+		return nil, false
+	}
+
+	now := time.Now
+	if sf.now != nil {
+		now = sf.now
+	}
+	counter := now().Unix() / int64(sf.period()/time.Second)
+
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	last, hasLast := sf.used[user]
+	for i := -sf.Window; i <= sf.Window; i++ {
+		c := counter + int64(i)
+		// a code is only valid once, as is any code older than it
+		if hasLast && c <= last {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(code), []byte(hotp(secret, c, sf.digits()))) == 1 {
+			if sf.used == nil {
+				sf.used = make(map[string]int64)
+			}
+			sf.used[user] = c
+			return identity, true
+		}
+	}
+	return nil, false
+}
+
+func (sf *TOTPCredentials) digits() int {
+	if sf.Digits <= 0 {
+		return 6
+	}
+	return sf.Digits
+}
+
+func (sf *TOTPCredentials) period() time.Duration {
+	if sf.Period < time.Second {
+		return 30 * time.Second
+	}
+	return sf.Period
+}
+
+// hotp computes the RFC 4226 one-time password for the counter
+func hotp(secret []byte, counter int64, digits int) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:]) //nolint: errcheck
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := int64(binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff)
+	mod := int64(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	code := strconv.FormatInt(value%mod, 10)
+	return strings.Repeat("0", digits-len(code)) + code
+}
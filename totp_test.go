@@ -0,0 +1,40 @@
+package socks5
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHOTP(t *testing.T) {
+	// RFC 6238 appendix B, SHA1
+	secret := []byte("12345678901234567890")
+	assert.Equal(t, "94287082", hotp(secret, 59/30, 8))
+	assert.Equal(t, "07081804", hotp(secret, 1111111109/30, 8))
+	assert.Equal(t, "14050471", hotp(secret, 1111111111/30, 8))
+}
+
+func TestTOTPCredentials(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111109, 0)
+
+	creds := NewTOTPCredentials(StaticCredentials{"foo": "bar", "baz": "qux"}, map[string][]byte{"foo": secret})
+	creds.now = func() time.Time { return now }
+
+	code := hotp(secret, now.Unix()/30, 6)
+	prev := hotp(secret, now.Unix()/30-1, 6)
+
+	assert.False(t, creds.Valid("foo", "bar", ""))
+	assert.False(t, creds.Valid("foo", "baz+"+code, ""))
+	assert.False(t, creds.Valid("baz", "qux+"+code, ""))
+	assert.False(t, creds.Valid("foo", "bar+"+hotp(secret, now.Unix()/30+5, 6), ""))
+
+	assert.True(t, creds.Valid("foo", "bar+"+code, ""))
+	// replay of the same code, or an older one, is refused
+	assert.False(t, creds.Valid("foo", "bar+"+code, ""))
+	assert.False(t, creds.Valid("foo", "bar+"+prev, ""))
+
+	now = now.Add(30 * time.Second)
+	assert.True(t, creds.Valid("foo", "bar+"+hotp(secret, now.Unix()/30, 6), ""))
+}
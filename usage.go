@@ -0,0 +1,319 @@
+package socks5
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SessionRecord is the summary of a finished request
+type SessionRecord struct {
+	// Start of the request, after the negotiation
+	Start time.Time
+	// Duration until the request was done
+	Duration time.Duration
+	// User the authenticated username, empty without user/pass auth
+	User string
+	// Command of the request
+	Command uint8
+	// Domain the requested FQDN, or the IP if the client sent an address
+	Domain string
+	// Resolver the name of the resolver selected for the request
+	Resolver string
+	// Status the reply code, if a reply was sent
+	Status  uint8
+	Replied bool
+	// BytesIn read from the client and BytesOut written to the client,
+	// over the TCP connection; datagrams of udp associate and the writes
+	// of the handlers set with WithConnectHandle and alike are not counted.
+	BytesIn  int64
+	BytesOut int64
+}
+
+// SessionRecorder receives a record of every finished request
+type SessionRecorder interface {
+	RecordSession(rec SessionRecord)
+}
+
+func newSessionRecord(req *Request, rsp *response, start time.Time) SessionRecord {
+	rec := SessionRecord{
+		Start:    start,
+		Duration: time.Since(start),
+		Command:  req.Command,
+		Resolver: req.Resolver,
+		BytesIn:  rsp.BytesRead(),
+		BytesOut: rsp.BytesWritten(),
+	}
+	if req.AuthContext != nil {
+		rec.User = req.AuthContext.Payload["username"]
+	}
+	if req.RawDestAddr != nil {
+		rec.Domain = req.RawDestAddr.FQDN
+		if rec.Domain == "" && req.RawDestAddr.IP != nil {
+			rec.Domain = req.RawDestAddr.IP.String()
+		}
+	}
+	rec.Status, rec.Replied = rsp.Status()
+	return rec
+}
+
+// UsageDimension is what usage is rolled up by
+type UsageDimension string
+
+// usage dimension defined
+const (
+	UsageByUser   UsageDimension = "user"
+	UsageByDomain UsageDimension = "domain"
+)
+
+// UsagePeriod is the time bucket usage is rolled up in, in UTC
+type UsagePeriod string
+
+// usage period defined
+const (
+	UsageHourly UsagePeriod = "hour"
+	UsageDaily  UsagePeriod = "day"
+)
+
+// truncate returns the start of the period containing t
+func (sf UsagePeriod) truncate(t time.Time) time.Time {
+	t = t.UTC()
+	if sf == UsageDaily {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return t.Truncate(time.Hour)
+}
+
+// UsageTotals is the usage of a user or a domain in a period
+type UsageTotals struct {
+	Dimension UsageDimension `json:"dimension"`
+	Period    UsagePeriod    `json:"period"`
+	Start     time.Time      `json:"start"`
+	Name      string         `json:"name"`
+	Sessions  int64          `json:"sessions"`
+	BytesIn   int64          `json:"bytes_in"`
+	BytesOut  int64          `json:"bytes_out"`
+	Duration  time.Duration  `json:"duration"`
+}
+
+// Bytes returns the bytes transferred in both directions
+func (sf UsageTotals) Bytes() int64 { return sf.BytesIn + sf.BytesOut }
+
+type usageKey struct {
+	dimension UsageDimension
+	period    UsagePeriod
+	start     int64
+	name      string
+}
+
+// UsageRollup is a SessionRecorder which rolls up the bytes, sessions and
+// durations per user and per destination domain, by hour and by day.
+// A session is accounted entirely to the period it started in.
+type UsageRollup struct {
+	mu      sync.Mutex
+	rollups map[usageKey]*UsageTotals
+}
+
+// NewUsageRollup new empty usage rollup
+func NewUsageRollup() *UsageRollup {
+	return &UsageRollup{rollups: make(map[usageKey]*UsageTotals)}
+}
+
+// RecordSession implement interface SessionRecorder
+func (sf *UsageRollup) RecordSession(rec SessionRecord) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	for _, period := range []UsagePeriod{UsageHourly, UsageDaily} {
+		start := period.truncate(rec.Start)
+		sf.add(UsageTotals{UsageByUser, period, start, rec.User, 1, rec.BytesIn, rec.BytesOut, rec.Duration})
+		sf.add(UsageTotals{UsageByDomain, period, start, rec.Domain, 1, rec.BytesIn, rec.BytesOut, rec.Duration})
+	}
+}
+
+// add merges totals into the rollups, sf.mu must be held
+func (sf *UsageRollup) add(t UsageTotals) {
+	key := usageKey{t.Dimension, t.Period, t.Start.Unix(), t.Name}
+	r, ok := sf.rollups[key]
+	if !ok {
+		r = &UsageTotals{Dimension: t.Dimension, Period: t.Period, Start: t.Start.UTC(), Name: t.Name}
+		sf.rollups[key] = r
+	}
+	r.Sessions += t.Sessions
+	r.BytesIn += t.BytesIn
+	r.BytesOut += t.BytesOut
+	r.Duration += t.Duration
+}
+
+// Rollups returns all the rollups, ordered by dimension, period, start and name
+func (sf *UsageRollup) Rollups() []UsageTotals {
+	sf.mu.Lock()
+	list := make([]UsageTotals, 0, len(sf.rollups))
+	for _, r := range sf.rollups {
+		list = append(list, *r)
+	}
+	sf.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.Dimension != b.Dimension {
+			return a.Dimension < b.Dimension
+		}
+		if a.Period != b.Period {
+			return a.Period < b.Period
+		}
+		if !a.Start.Equal(b.Start) {
+			return a.Start.Before(b.Start)
+		}
+		return a.Name < b.Name
+	})
+	return list
+}
+
+// Top returns the n users or domains with the most bytes in the period
+// containing at, a non positive n returns all of them.
+func (sf *UsageRollup) Top(dimension UsageDimension, period UsagePeriod, at time.Time, n int) []UsageTotals {
+	start := period.truncate(at).Unix()
+
+	sf.mu.Lock()
+	var list []UsageTotals
+	for k, r := range sf.rollups {
+		if k.dimension == dimension && k.period == period && k.start == start {
+			list = append(list, *r)
+		}
+	}
+	sf.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Bytes() != list[j].Bytes() {
+			return list[i].Bytes() > list[j].Bytes()
+		}
+		return list[i].Name < list[j].Name
+	})
+	if n > 0 && len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+// Prune drops the rollups of the periods which started before t
+func (sf *UsageRollup) Prune(before time.Time) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	for k, r := range sf.rollups {
+		if r.Start.Before(before) {
+			delete(sf.rollups, k)
+		}
+	}
+}
+
+// WriteJSON writes the rollups as a JSON array
+func (sf *UsageRollup) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(sf.Rollups())
+}
+
+// ReadJSON merges the rollups of a JSON array written by WriteJSON
+func (sf *UsageRollup) ReadJSON(r io.Reader) error {
+	var list []UsageTotals
+	if err := json.NewDecoder(r).Decode(&list); err != nil {
+		return err
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	for _, t := range list {
+		sf.add(t)
+	}
+	return nil
+}
+
+// WriteCSV writes the rollups as CSV with a header line,
+// the duration in seconds.
+func (sf *UsageRollup) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"dimension", "period", "start", "name", "sessions", "bytes_in", "bytes_out", "duration"}) //nolint: errcheck
+	for _, r := range sf.Rollups() {
+		cw.Write([]string{ //nolint: errcheck
+			string(r.Dimension),
+			string(r.Period),
+			r.Start.Format(time.RFC3339),
+			r.Name,
+			strconv.FormatInt(r.Sessions, 10),
+			strconv.FormatInt(r.BytesIn, 10),
+			strconv.FormatInt(r.BytesOut, 10),
+			strconv.FormatFloat(r.Duration.Seconds(), 'f', -1, 64),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Save writes the rollups to the file, as CSV if its name ends with
+// ".csv" and JSON otherwise. The file is replaced atomically.
+func (sf *UsageRollup) Save(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) //nolint: errcheck
+
+	if strings.HasSuffix(path, ".csv") {
+		err = sf.WriteCSV(f)
+	} else {
+		err = sf.WriteJSON(f)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// ServeHTTP serves top-N queries as JSON. The query parameters are
+// "dimension" (user or domain), "period" (hour or day), "at" a RFC 3339
+// time within the period, defaults to now, and "n", defaults to 10.
+func (sf *UsageRollup) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	dimension := UsageDimension(q.Get("dimension"))
+	if dimension != UsageByUser && dimension != UsageByDomain {
+		http.Error(w, fmt.Sprintf("invalid dimension %q", dimension), http.StatusBadRequest)
+		return
+	}
+	period := UsagePeriod(q.Get("period"))
+	if period == "" {
+		period = UsageDaily
+	}
+	if period != UsageHourly && period != UsageDaily {
+		http.Error(w, fmt.Sprintf("invalid period %q", period), http.StatusBadRequest)
+		return
+	}
+	at := time.Now()
+	if s := q.Get("at"); s != "" {
+		var err error
+		if at, err = time.Parse(time.RFC3339, s); err != nil {
+			http.Error(w, fmt.Sprintf("invalid at, %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	n := 10
+	if s := q.Get("n"); s != "" {
+		var err error
+		if n, err = strconv.Atoi(s); err != nil {
+			http.Error(w, fmt.Sprintf("invalid n, %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sf.Top(dimension, period, at, n)) //nolint: errcheck
+}
@@ -0,0 +1,132 @@
+package socks5
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/things-go/go-socks5/statute"
+)
+
+type recordList struct {
+	mu   sync.Mutex
+	list []SessionRecord
+}
+
+func (sf *recordList) RecordSession(rec SessionRecord) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.list = append(sf.list, rec)
+}
+
+func TestSessionRecorder_Connect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 4)
+		io.ReadFull(conn, buf)     //nolint: errcheck
+		conn.Write([]byte("pong")) //nolint: errcheck
+	}()
+	lAddr := l.Addr().(*net.TCPAddr)
+
+	records := new(recordList)
+	srv := NewServer(WithSessionRecorder(records))
+
+	reqHead := statute.Request{
+		Version: statute.VersionSocks5,
+		Command: statute.CommandConnect,
+		DstAddr: statute.AddrSpec{FQDN: "LocalHost.", Port: lAddr.Port, AddrType: statute.ATYPDomain},
+	}
+	buf := bytes.NewBuffer(reqHead.Bytes())
+	buf.WriteString("ping")
+	req, err := ParseRequest(buf)
+	require.NoError(t, err)
+	req.AuthContext = &AuthContext{statute.MethodUserPassAuth, map[string]string{"username": "foo"}}
+	require.NoError(t, srv.handleRequest(new(MockConn), req))
+
+	require.Len(t, records.list, 1)
+	rec := records.list[0]
+	assert.Equal(t, "foo", rec.User)
+	assert.Equal(t, "localhost", rec.Domain)
+	assert.Equal(t, DefaultResolverName, rec.Resolver)
+	assert.Equal(t, statute.CommandConnect, rec.Command)
+	assert.True(t, rec.Replied)
+	assert.Equal(t, statute.RepSuccess, rec.Status)
+	assert.Equal(t, int64(4), rec.BytesIn)
+	assert.Equal(t, int64(4), rec.BytesOut)
+}
+
+func TestUsageRollup(t *testing.T) {
+	at := time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)
+	u := NewUsageRollup()
+	u.RecordSession(SessionRecord{Start: at, Duration: time.Second, User: "foo", Domain: "a.example", BytesIn: 10, BytesOut: 100})
+	u.RecordSession(SessionRecord{Start: at.Add(time.Minute), Duration: time.Second, User: "foo", Domain: "b.example", BytesIn: 5, BytesOut: 5})
+	u.RecordSession(SessionRecord{Start: at.Add(time.Hour), Duration: time.Second, User: "bar", Domain: "b.example", BytesIn: 1000, BytesOut: 1000})
+
+	top := u.Top(UsageByUser, UsageHourly, at, 10)
+	require.Len(t, top, 1)
+	assert.Equal(t, UsageTotals{UsageByUser, UsageHourly, at.Truncate(time.Hour), "foo", 2, 15, 105, 2 * time.Second}, top[0])
+
+	top = u.Top(UsageByUser, UsageDaily, at, 10)
+	require.Len(t, top, 2)
+	assert.Equal(t, "bar", top[0].Name)
+	assert.Equal(t, "foo", top[1].Name)
+
+	top = u.Top(UsageByDomain, UsageDaily, at, 1)
+	require.Len(t, top, 1)
+	assert.Equal(t, "b.example", top[0].Name)
+	assert.Equal(t, int64(2), top[0].Sessions)
+
+	t.Run("persist", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, u.Save(filepath.Join(dir, "usage.json")))
+		require.NoError(t, u.Save(filepath.Join(dir, "usage.csv")))
+
+		f, err := os.Open(filepath.Join(dir, "usage.json"))
+		require.NoError(t, err)
+		defer f.Close()
+		restored := NewUsageRollup()
+		require.NoError(t, restored.ReadJSON(f))
+		assert.Equal(t, u.Rollups(), restored.Rollups())
+
+		b, err := os.ReadFile(filepath.Join(dir, "usage.csv"))
+		require.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+		assert.Len(t, lines, 1+len(u.Rollups()))
+		assert.Contains(t, lines, "user,hour,2024-05-01T10:00:00Z,foo,2,15,105,2")
+	})
+
+	t.Run("http", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		u.ServeHTTP(w, httptest.NewRequest("GET", "/?dimension=domain&period=day&n=5&at=2024-05-01T00:00:00Z", nil))
+		require.Equal(t, 200, w.Code)
+		var got []UsageTotals
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+		assert.Equal(t, u.Top(UsageByDomain, UsageDaily, at, 5), got)
+
+		w = httptest.NewRecorder()
+		u.ServeHTTP(w, httptest.NewRequest("GET", "/?dimension=bogus", nil))
+		assert.Equal(t, 400, w.Code)
+	})
+
+	u.Prune(at.Truncate(time.Hour).Add(time.Hour))
+	assert.Empty(t, u.Top(UsageByUser, UsageDaily, at, 0))
+	assert.Len(t, u.Top(UsageByUser, UsageHourly, at.Add(time.Hour), 0), 1)
+}